@@ -2,19 +2,198 @@
 
 package main
 
-import "github.com/golang-jwt/jwt/v5"
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
-func generateToken(secret string, streamId string) string {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"sub": "stream_play",
-		"sid": streamId,
-	})
+	"github.com/golang-jwt/jwt/v5"
+)
 
-	tokenb64, e := token.SignedString([]byte(secret))
+// Time the iat and nbf claims are backdated,
+// so the tokens are accepted by servers with a clock slightly behind
+const AUTH_TOKEN_CLOCK_LEEWAY = 30 * time.Second
+
+// Provides the authentication token for the source
+// Called on every connection, so the token can change between reconnections
+type AuthTokenProvider interface {
+	// Gets the current token
+	GetToken() (string, error)
+}
+
+// Fixed authentication token
+type StaticAuthToken string
+
+func (t StaticAuthToken) GetToken() (string, error) {
+	return string(t), nil
+}
+
+// Configuration to generate authentication tokens
+type AuthTokenConfig struct {
+	// Signing method
+	method jwt.SigningMethod
+
+	// Key to sign the tokens
+	key interface{}
+
+	// Key ID for the 'kid' header (optional)
+	keyId string
+
+	// Time to live of the tokens (0 = no expiration)
+	ttl time.Duration
+
+	// Extra claims
+	extraClaims map[string]interface{}
+}
+
+// Loads the token generation configuration
+// secret - Secret for HMAC methods (from the --secret option)
+// Returns nil if there is no secret nor private key configured
+func loadAuthTokenConfig(secret string) *AuthTokenConfig {
+	config := &AuthTokenConfig{
+		method:      jwt.SigningMethodHS256,
+		keyId:       os.Getenv("AUTH_TOKEN_KEY_ID"),
+		ttl:         time.Hour,
+		extraClaims: make(map[string]interface{}),
+	}
+
+	algorithm := strings.ToUpper(os.Getenv("AUTH_TOKEN_ALGORITHM"))
+	keyFile := os.Getenv("AUTH_TOKEN_PRIVATE_KEY_FILE")
+
+	if algorithm == "" {
+		if keyFile != "" {
+			fmt.Println("Please set AUTH_TOKEN_ALGORITHM when using AUTH_TOKEN_PRIVATE_KEY_FILE.")
+			os.Exit(1)
+		}
+		algorithm = "HS256"
+	}
+
+	switch algorithm {
+	case "HS256":
+		if secret == "" {
+			return nil
+		}
+		config.method = jwt.SigningMethodHS256
+		config.key = []byte(secret)
+	case "RS256", "ES256", "EDDSA":
+		if keyFile == "" {
+			fmt.Println("Please set AUTH_TOKEN_PRIVATE_KEY_FILE when using the " + algorithm + " algorithm.")
+			os.Exit(1)
+		}
+
+		keyPEM, err := os.ReadFile(keyFile)
+		if err != nil {
+			fmt.Println("Error: Could not read AUTH_TOKEN_PRIVATE_KEY_FILE: " + err.Error())
+			os.Exit(1)
+		}
+
+		switch algorithm {
+		case "RS256":
+			config.method = jwt.SigningMethodRS256
+			config.key, err = jwt.ParseRSAPrivateKeyFromPEM(keyPEM)
+		case "ES256":
+			config.method = jwt.SigningMethodES256
+
+			var ecKey *ecdsa.PrivateKey
+			ecKey, err = jwt.ParseECPrivateKeyFromPEM(keyPEM)
+
+			if err == nil && ecKey.Curve != elliptic.P256() {
+				// Other curves fail when signing the first token
+				err = errors.New("ES256 requires a P-256 key, found " + ecKey.Curve.Params().Name)
+			}
+
+			config.key = ecKey
+		default:
+			config.method = jwt.SigningMethodEdDSA
+			config.key, err = jwt.ParseEdPrivateKeyFromPEM(keyPEM)
+		}
+
+		if err != nil {
+			fmt.Println("Error: Invalid private key for " + algorithm + " in AUTH_TOKEN_PRIVATE_KEY_FILE: " + err.Error())
+			os.Exit(1)
+		}
+	default:
+		fmt.Println("Invalid AUTH_TOKEN_ALGORITHM provided. It can be HS256, RS256, ES256 or EdDSA.")
+		os.Exit(1)
+	}
+
+	if os.Getenv("AUTH_TOKEN_TTL") != "" {
+		ttl, err := strconv.Atoi(os.Getenv("AUTH_TOKEN_TTL"))
+		if err != nil || ttl < 0 {
+			fmt.Println("Invalid AUTH_TOKEN_TTL provided. It must be a number of seconds.")
+			os.Exit(1)
+		}
+		config.ttl = time.Duration(ttl) * time.Second
+	}
+
+	if os.Getenv("AUTH_TOKEN_CLAIMS") != "" {
+		err := json.Unmarshal([]byte(os.Getenv("AUTH_TOKEN_CLAIMS")), &config.extraClaims)
+		if err != nil {
+			fmt.Println("Invalid AUTH_TOKEN_CLAIMS provided. It must be a JSON object. Example: {\"aud\": \"webrtc-cdn\"}")
+			os.Exit(1)
+		}
+	}
+
+	return config
+}
+
+// Generates authentication tokens for a stream
+type AuthTokenGenerator struct {
+	config   *AuthTokenConfig
+	streamId string
+}
+
+// Creates a token generator
+// config - Token generation configuration
+// streamId - ID of the stream to play
+func NewAuthTokenGenerator(config *AuthTokenConfig, streamId string) *AuthTokenGenerator {
+	return &AuthTokenGenerator{
+		config:   config,
+		streamId: streamId,
+	}
+}
+
+// Generates a new token, valid from now (minus the clock leeway)
+func (g *AuthTokenGenerator) GetToken() (string, error) {
+	now := time.Now()
+	notBefore := now.Add(-AUTH_TOKEN_CLOCK_LEEWAY)
+
+	claims := jwt.MapClaims{}
+
+	for key, val := range g.config.extraClaims {
+		if str, ok := val.(string); ok {
+			// Claim template
+			val = strings.ReplaceAll(str, "{stream_id}", g.streamId)
+		}
+		claims[key] = val
+	}
+
+	claims["sub"] = "stream_play"
+	claims["sid"] = g.streamId
+	claims["iat"] = notBefore.Unix()
+	claims["nbf"] = notBefore.Unix()
+
+	if g.config.ttl > 0 {
+		claims["exp"] = now.Add(g.config.ttl).Unix()
+	}
+
+	token := jwt.NewWithClaims(g.config.method, claims)
+
+	if g.config.keyId != "" {
+		token.Header["kid"] = g.config.keyId
+	}
+
+	tokenb64, e := token.SignedString(g.config.key)
 
 	if e != nil {
-		return ""
+		return "", errors.New("could not generate the authentication token: " + e.Error())
 	}
 
-	return tokenb64
+	return tokenb64, nil
 }
@@ -0,0 +1,279 @@
+// External authentication token providers
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Max size of a token obtained from an external provider
+const AUTH_TOKEN_MAX_SIZE = 64 * 1024
+
+// Loads the authentication token provider for the source
+// authToken - Token from the --auth option
+// authSecret - Secret from the --secret option
+// streamId - ID of the stream to play
+// Returns nil if no authentication is configured
+func loadAuthTokenProvider(authToken string, authSecret string, streamId string) AuthTokenProvider {
+	timeout := 10 * time.Second
+
+	if os.Getenv("AUTH_TOKEN_PROVIDER_TIMEOUT") != "" {
+		t, err := strconv.Atoi(os.Getenv("AUTH_TOKEN_PROVIDER_TIMEOUT"))
+		if err != nil || t <= 0 {
+			fmt.Println("Invalid AUTH_TOKEN_PROVIDER_TIMEOUT provided. It must be a number of seconds.")
+			os.Exit(1)
+		}
+		timeout = time.Duration(t) * time.Second
+	}
+
+	providers := make([]AuthTokenProvider, 0)
+
+	if authToken != "" {
+		providers = append(providers, StaticAuthToken(authToken))
+	}
+
+	if authTokenConfig := loadAuthTokenConfig(authSecret); authTokenConfig != nil {
+		providers = append(providers, NewAuthTokenGenerator(authTokenConfig, streamId))
+	}
+
+	if os.Getenv("AUTH_TOKEN_FILE") != "" {
+		providers = append(providers, NewFileAuthToken(os.Getenv("AUTH_TOKEN_FILE")))
+	}
+
+	if os.Getenv("AUTH_TOKEN_COMMAND") != "" {
+		args, err := splitCommandLine(os.Getenv("AUTH_TOKEN_COMMAND"))
+		if err != nil || len(args) == 0 {
+			fmt.Println("Invalid AUTH_TOKEN_COMMAND provided. It must be a command line.")
+			os.Exit(1)
+		}
+		providers = append(providers, &CommandAuthToken{
+			args:     args,
+			streamId: streamId,
+			timeout:  timeout,
+		})
+	}
+
+	if os.Getenv("AUTH_TOKEN_URL") != "" {
+		u, err := url.Parse(os.Getenv("AUTH_TOKEN_URL"))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			fmt.Println("Invalid AUTH_TOKEN_URL provided. It must be a valid http or https URL.")
+			os.Exit(1)
+		}
+
+		registerURLSecrets(u.String())
+
+		headers := make(map[string]string)
+
+		if headersJSON := getSecretEnv("AUTH_TOKEN_URL_HEADERS"); headersJSON != "" {
+			err := json.Unmarshal([]byte(headersJSON), &headers)
+			if err != nil {
+				fmt.Println("Invalid AUTH_TOKEN_URL_HEADERS provided. It must be a JSON object.")
+				os.Exit(1)
+			}
+
+			for _, val := range headers {
+				registerSecret(val)
+			}
+		}
+
+		providers = append(providers, &HTTPAuthToken{
+			url:      u.String(),
+			headers:  headers,
+			streamId: streamId,
+			client: &http.Client{
+				Timeout: timeout,
+			},
+		})
+	}
+
+	if len(providers) > 1 {
+		fmt.Println("Only one authentication method can be used: --auth, --secret (or AUTH_TOKEN_PRIVATE_KEY_FILE), AUTH_TOKEN_FILE, AUTH_TOKEN_COMMAND or AUTH_TOKEN_URL")
+		os.Exit(1)
+	}
+
+	if len(providers) == 0 {
+		return nil
+	}
+
+	return providers[0]
+}
+
+// Normalizes a token obtained from an external provider
+func parseExternalToken(raw []byte) (string, error) {
+	token := strings.TrimSpace(string(raw))
+
+	if token == "" {
+		return "", errors.New("empty token")
+	}
+
+	if strings.ContainsAny(token, "\r\n") {
+		return "", errors.New("the token contains line breaks")
+	}
+
+	return token, nil
+}
+
+// Token read from a file
+// The file is read again when it changes (for example, rotated secrets mounted as files)
+type FileAuthToken struct {
+	lock *sync.Mutex
+
+	file string
+
+	token   string
+	modTime time.Time
+	size    int64
+}
+
+// Creates a file token provider
+// file - Path to the file containing the token
+func NewFileAuthToken(file string) *FileAuthToken {
+	return &FileAuthToken{
+		lock: &sync.Mutex{},
+		file: file,
+	}
+}
+
+func (p *FileAuthToken) GetToken() (string, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	info, err := os.Stat(p.file)
+
+	if err != nil {
+		return "", errors.New("could not read the token file: " + err.Error())
+	}
+
+	if p.token != "" && info.ModTime().Equal(p.modTime) && info.Size() == p.size {
+		return p.token, nil
+	}
+
+	content, err := os.ReadFile(p.file)
+
+	if err != nil {
+		return "", errors.New("could not read the token file: " + err.Error())
+	}
+
+	token, err := parseExternalToken(content)
+
+	if err != nil {
+		return "", errors.New("invalid token file: " + err.Error())
+	}
+
+	p.token = token
+	p.modTime = info.ModTime()
+	p.size = info.Size()
+
+	return token, nil
+}
+
+// Token printed by a command
+type CommandAuthToken struct {
+	args     []string
+	streamId string
+	timeout  time.Duration
+}
+
+func (p *CommandAuthToken) GetToken() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, p.args[0], p.args[1:]...)
+
+	cmd.Env = append(os.Environ(), "FORWARDER_STREAM_ID="+p.streamId)
+	cmd.Stderr = NewRedactingWriter(os.Stderr)
+
+	out, err := cmd.Output()
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", errors.New("the token command timed out after " + fmt.Sprint(p.timeout))
+	} else if err != nil {
+		return "", errors.New("the token command failed: " + err.Error())
+	}
+
+	token, err := parseExternalToken(out)
+
+	if err != nil {
+		return "", errors.New("invalid token command output: " + err.Error())
+	}
+
+	return token, nil
+}
+
+// Token obtained from an HTTP endpoint
+// The response body can be the token, or a JSON object with a 'token' field
+type HTTPAuthToken struct {
+	url      string
+	headers  map[string]string
+	streamId string
+	client   *http.Client
+}
+
+func (p *HTTPAuthToken) GetToken() (string, error) {
+	req, err := http.NewRequest("GET", p.url, nil)
+
+	if err != nil {
+		return "", err
+	}
+
+	for key, val := range p.headers {
+		req.Header.Set(key, val)
+	}
+
+	req.Header.Set("X-Stream-ID", p.streamId)
+
+	res, err := p.client.Do(req)
+
+	if err != nil {
+		return "", errors.New("could not obtain the token: " + err.Error())
+	}
+
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, AUTH_TOKEN_MAX_SIZE))
+
+	if err != nil {
+		return "", errors.New("could not obtain the token: " + err.Error())
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return "", errors.New("could not obtain the token: status " + fmt.Sprint(res.StatusCode))
+	}
+
+	body = bytes.TrimSpace(body)
+
+	if len(body) > 0 && body[0] == '{' {
+		tokenResponse := struct {
+			Token string `json:"token"`
+		}{}
+
+		err = json.Unmarshal(body, &tokenResponse)
+
+		if err != nil {
+			return "", errors.New("invalid token response: " + err.Error())
+		}
+
+		body = []byte(tokenResponse.Token)
+	}
+
+	token, err := parseExternalToken(body)
+
+	if err != nil {
+		return "", errors.New("invalid token response: " + err.Error())
+	}
+
+	return token, nil
+}
@@ -0,0 +1,142 @@
+// Tests for the external authentication token providers
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Creates an HTTP token provider for a test server
+func newTestHTTPAuthToken(t *testing.T, handler http.HandlerFunc) *HTTPAuthToken {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	return &HTTPAuthToken{
+		url:      server.URL,
+		headers:  map[string]string{"Authorization": "Bearer secret"},
+		streamId: "stream",
+		client:   server.Client(),
+	}
+}
+
+func TestHTTPAuthToken(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		token       string
+	}{
+		{"plain text", http.StatusOK, "text/plain", "plain-token\n", "plain-token"},
+		{"json", http.StatusOK, "application/json", "{\"token\": \"json-token\", \"expires_in\": 3600}", "json-token"},
+		{"json with spaces", http.StatusOK, "application/json", "\n  {\"token\": \"json-token\"}\n", "json-token"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := newTestHTTPAuthToken(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") != "Bearer secret" {
+					t.Errorf("Missing header. Authorization: %q", r.Header.Get("Authorization"))
+				}
+
+				if r.Header.Get("X-Stream-ID") != "stream" {
+					t.Errorf("Missing stream ID. X-Stream-ID: %q", r.Header.Get("X-Stream-ID"))
+				}
+
+				w.Header().Set("Content-Type", test.contentType)
+				w.WriteHeader(test.status)
+				w.Write([]byte(test.body))
+			})
+
+			token, err := provider.GetToken()
+			if err != nil {
+				t.Fatalf("GetToken failed: %v", err)
+			}
+
+			if token != test.token {
+				t.Fatalf("Unexpected token: %q (expected %q)", token, test.token)
+			}
+		})
+	}
+}
+
+func TestHTTPAuthTokenInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+	}{
+		{"error status", http.StatusForbidden, "token"},
+		{"empty body", http.StatusOK, ""},
+		{"invalid json", http.StatusOK, "{\"token\": "},
+		{"json without token", http.StatusOK, "{\"access_token\": \"token\"}"},
+		{"line breaks", http.StatusOK, "token\nother"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := newTestHTTPAuthToken(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.status)
+				w.Write([]byte(test.body))
+			})
+
+			token, err := provider.GetToken()
+			if err == nil {
+				t.Fatalf("Expected an error, got: %q", token)
+			}
+		})
+	}
+}
+
+func TestFileAuthTokenRotation(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "token")
+
+	if err := os.WriteFile(file, []byte("first-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	provider := NewFileAuthToken(file)
+
+	token, err := provider.GetToken()
+	if err != nil {
+		t.Fatalf("GetToken failed: %v", err)
+	}
+
+	if token != "first-token" {
+		t.Fatalf("Unexpected token: %q", token)
+	}
+
+	// Rotate the token, with a different modification time
+	if err := os.WriteFile(file, []byte("second-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	modTime := time.Now().Add(time.Minute)
+
+	if err := os.Chtimes(file, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err = provider.GetToken()
+	if err != nil {
+		t.Fatalf("GetToken failed: %v", err)
+	}
+
+	if token != "second-token" {
+		t.Fatalf("The rotated token was not picked up. Token: %q", token)
+	}
+
+	// Removed file
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+
+	if token, err = provider.GetToken(); err == nil {
+		t.Fatalf("Expected an error for the removed file, got: %q", token)
+	}
+}
@@ -0,0 +1,202 @@
+// Custom commands
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Information of the stream, available to the outputs
+type StreamInfo struct {
+	// ID of the source stream
+	streamId string
+
+	// Video codec. Example: VP8, H264
+	videoCodec string
+
+	// Audio codec. Example: OPUS
+	audioCodec string
+}
+
+// Gets the codec name from its mime type
+// Example: video/VP8 -> VP8
+func codecNameFromMimeType(mimeType string) string {
+	slashIndex := strings.Index(mimeType, "/")
+
+	if slashIndex >= 0 {
+		return strings.ToUpper(mimeType[slashIndex+1:])
+	}
+
+	return strings.ToUpper(mimeType)
+}
+
+// Splits a command line into arguments
+// The command can be a JSON array of strings, or a command line
+// using shell-style quoting (single quotes, double quotes and backslash escapes)
+func splitCommandLine(command string) ([]string, error) {
+	command = strings.TrimSpace(command)
+
+	if strings.HasPrefix(command, "[") {
+		args := make([]string, 0)
+
+		err := json.Unmarshal([]byte(command), &args)
+
+		if err != nil {
+			return nil, errors.New("invalid JSON command: " + err.Error())
+		}
+
+		if len(args) == 0 {
+			return nil, errors.New("empty command")
+		}
+
+		return args, nil
+	}
+
+	args := make([]string, 0)
+
+	current := strings.Builder{}
+	inArg := false
+	inSingleQuotes := false
+	inDoubleQuotes := false
+	escaped := false
+
+	for _, c := range command {
+		if escaped {
+			if inDoubleQuotes && c != '"' && c != '\\' && c != '$' && c != '`' {
+				current.WriteRune('\\')
+			}
+			current.WriteRune(c)
+			escaped = false
+			continue
+		}
+
+		if inSingleQuotes {
+			if c == '\'' {
+				inSingleQuotes = false
+			} else {
+				current.WriteRune(c)
+			}
+			continue
+		}
+
+		if inDoubleQuotes {
+			if c == '"' {
+				inDoubleQuotes = false
+			} else if c == '\\' {
+				escaped = true
+			} else {
+				current.WriteRune(c)
+			}
+			continue
+		}
+
+		switch c {
+		case ' ', '\t', '\n', '\r':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		case '\'':
+			inSingleQuotes = true
+			inArg = true
+		case '"':
+			inDoubleQuotes = true
+			inArg = true
+		case '\\':
+			escaped = true
+			inArg = true
+		default:
+			current.WriteRune(c)
+			inArg = true
+		}
+	}
+
+	if escaped || inSingleQuotes || inDoubleQuotes {
+		return nil, errors.New("unterminated quote or escape in command")
+	}
+
+	if inArg {
+		args = append(args, current.String())
+	}
+
+	if len(args) == 0 {
+		return nil, errors.New("empty command")
+	}
+
+	return args, nil
+}
+
+// Gets the values for the placeholders of a custom command
+func getCommandPlaceholders(output OutputConfig, info StreamInfo) map[string]string {
+	return map[string]string{
+		"sdp_file":    output.SDPFile,
+		"video_port":  fmt.Sprint(output.VideoPort),
+		"audio_port":  fmt.Sprint(output.AudioPort),
+		"stream_id":   info.streamId,
+		"video_codec": info.videoCodec,
+		"audio_codec": info.audioCodec,
+		"output_name": output.Name,
+	}
+}
+
+// Registers the secret parts of the URL in a command argument, if any
+// The URL can be preceded by other options. Example: [f=flv]rtmp://host/app/key
+func registerArgumentSecrets(arg string) {
+	i := strings.Index(arg, "://")
+
+	if i <= 0 {
+		return
+	}
+
+	start := i
+
+	for start > 0 && isURLSchemeChar(arg[start-1]) {
+		start--
+	}
+
+	registerURLSecrets(arg[start:])
+}
+
+// Checks if a character can be part of an URL scheme
+func isURLSchemeChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '+' || c == '-' || c == '.'
+}
+
+// Builds the command for a CUSTOM output
+// Placeholders like {sdp_file} are replaced in each argument,
+// and their values are also exported as FORWARDER_* environment variables
+func buildCustomCommand(output OutputConfig, info StreamInfo) (*exec.Cmd, error) {
+	args, err := splitCommandLine(output.Command)
+
+	if err != nil {
+		return nil, err
+	}
+
+	placeholders := getCommandPlaceholders(output, info)
+
+	replacements := make([]string, 0, len(placeholders)*2)
+	env := os.Environ()
+
+	for key, val := range placeholders {
+		replacements = append(replacements, "{"+key+"}", val)
+		env = append(env, "FORWARDER_"+strings.ToUpper(key)+"="+val)
+	}
+
+	replacer := strings.NewReplacer(replacements...)
+
+	for i := range args {
+		args[i] = replacer.Replace(args[i])
+		registerArgumentSecrets(args[i])
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = env
+
+	return cmd, nil
+}
@@ -0,0 +1,157 @@
+// Lifecycle events
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Lifecycle events
+const (
+	EVENT_FORWARD_STARTED     = "forward_started"
+	EVENT_SIGNALING_CONNECTED = "signaling_connected"
+	EVENT_STANDBY             = "standby"
+	EVENT_OFFER_RECEIVED      = "offer_received"
+	EVENT_TRACKS_READY        = "tracks_ready"
+	EVENT_OUTPUT_STARTED      = "output_started"
+	EVENT_OUTPUT_LIVE         = "output_live"
+	EVENT_OUTPUT_EXITED       = "output_exited"
+	EVENT_RECONNECTING        = "reconnecting"
+	EVENT_ICE_RESTARTING      = "ice_restarting"
+	EVENT_MEDIA_INACTIVE      = "media_inactive"
+	EVENT_MEDIA_RESUMED       = "media_resumed"
+	EVENT_DISCONNECTED        = "disconnected"
+	EVENT_FORWARD_ENDED       = "forward_ended"
+)
+
+// List of all the lifecycle events
+var LIFECYCLE_EVENTS = []string{
+	EVENT_FORWARD_STARTED,
+	EVENT_SIGNALING_CONNECTED,
+	EVENT_STANDBY,
+	EVENT_OFFER_RECEIVED,
+	EVENT_TRACKS_READY,
+	EVENT_OUTPUT_STARTED,
+	EVENT_OUTPUT_LIVE,
+	EVENT_OUTPUT_EXITED,
+	EVENT_RECONNECTING,
+	EVENT_ICE_RESTARTING,
+	EVENT_MEDIA_INACTIVE,
+	EVENT_MEDIA_RESUMED,
+	EVENT_DISCONNECTED,
+	EVENT_FORWARD_ENDED,
+}
+
+// Max time to wait for the handlers of the emitted events before exiting
+const EVENTS_EXIT_TIMEOUT = 30 * time.Second
+
+// Checks if a name is a lifecycle event
+func isLifecycleEvent(name string) bool {
+	for _, event := range LIFECYCLE_EVENTS {
+		if event == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Lifecycle event
+type LifecycleEvent struct {
+	// Event name
+	Event string `json:"event"`
+
+	// ID of the source stream
+	StreamId string `json:"stream_id"`
+
+	// Unix timestamp (milliseconds)
+	Timestamp int64 `json:"timestamp"`
+
+	// Event details
+	Details map[string]string `json:"details"`
+}
+
+var (
+	events_stream_id = ""
+	events_pending   = &sync.WaitGroup{}
+)
+
+// Initializes the lifecycle events
+func initEvents(streamId string) {
+	events_stream_id = streamId
+}
+
+// Emits a lifecycle event
+// The handlers run in background
+func emitEvent(event string, details map[string]string) {
+	if details == nil {
+		details = make(map[string]string)
+	}
+
+	for key, val := range details {
+		details[key] = redactSecrets(val)
+	}
+
+	e := LifecycleEvent{
+		Event:     event,
+		StreamId:  events_stream_id,
+		Timestamp: time.Now().UnixMilli(),
+		Details:   details,
+	}
+
+	if hasHook(event) {
+		events_pending.Add(1)
+		go func() {
+			defer events_pending.Done()
+			runHook(e)
+		}()
+	}
+
+	if hasWebhook(event) {
+		events_pending.Add(1)
+
+		select {
+		case webhooks_queue <- e:
+		default:
+			// Do not block the forward if the webhook is not keeping up
+			events_pending.Done()
+			fmt.Println("Warning: Webhook queue is full | Dropped event: " + event)
+		}
+	}
+}
+
+// Waits for the handlers of the emitted events to finish
+// Call before exiting, so they are not interrupted
+// Returns false if they did not finish before the timeout
+func waitPendingEvents(timeout time.Duration) bool {
+	done := make(chan bool)
+
+	go func() {
+		events_pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Ends the forward, exiting the program
+// Waits for the handlers of the emitted events before exiting
+func endForward(exitCode int) {
+	emitEvent(EVENT_FORWARD_ENDED, map[string]string{
+		"exit_code": fmt.Sprint(exitCode),
+	})
+
+	if !waitPendingEvents(EVENTS_EXIT_TIMEOUT) {
+		fmt.Println("Warning: The event handlers did not finish in " + fmt.Sprint(EVENTS_EXIT_TIMEOUT) + " | Exiting anyway")
+	}
+
+	os.Exit(exitCode)
+}
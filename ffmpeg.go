@@ -3,106 +3,109 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
-	"strings"
 	"sync"
 
 	child_process_manager "github.com/AgustinSRG/go-child-process-manager"
 )
 
-var (
-	forward_lock *sync.Mutex
-	forward_proc *os.Process
-)
+func forwardToRTMP(output OutputConfig, options ProcessOptions) error {
+	args := make([]string, 1)
 
-func initForward() {
-	forward_lock = &sync.Mutex{}
-	forward_proc = nil
-}
+	args[0] = options.ffmpeg
 
-func setProcess(p *os.Process) {
-	forward_lock.Lock()
-	defer forward_lock.Unlock()
+	// PROGRESS
+	args = append(args, "-progress", "pipe:1")
 
-	forward_proc = p
-}
+	args = append(args, "-re")
 
-func killProcess() {
-	forward_lock.Lock()
-	defer forward_lock.Unlock()
+	args = append(args, "-protocol_whitelist", "file,sdp,udp,rtp")
 
-	if forward_proc != nil {
-		forward_proc.Kill()
-		forward_proc = nil
-	}
+	// INPUT
+	args = append(args, "-f", "sdp", "-i", output.SDPFile)
+
+	// ENCODING
+	args = append(args, output.Args...)
+
+	// DESTINATION
+	args = append(args, "-f", "flv", output.URL)
+
+	cmd := exec.Command(options.ffmpeg)
+	cmd.Args = args
 
-	os.Exit(0)
+	return runOutputCommand(output.Name, cmd, true, options)
 }
 
-func forwardToRTMP(ffmpegBin string, source string, rtmpURL string, debug bool) {
+func forwardFFmpeg(output OutputConfig, options ProcessOptions) error {
 	args := make([]string, 1)
 
-	args[0] = ffmpegBin
+	args[0] = options.ffmpeg
+
+	// PROGRESS
+	args = append(args, "-progress", "pipe:1")
 
 	args = append(args, "-re")
 
 	args = append(args, "-protocol_whitelist", "file,sdp,udp,rtp")
 
 	// INPUT
-	args = append(args, "-f", "sdp", "-i", source)
+	args = append(args, "-f", "sdp", "-i", output.SDPFile)
+
+	// ENCODING
+	args = append(args, output.Args...)
 
 	// DESTINATION
-	args = append(args, "-f", "flv", rtmpURL)
+	if output.Format != "" {
+		args = append(args, "-f", output.Format)
+	}
 
-	cmd := exec.Command(ffmpegBin)
-	cmd.Args = args
+	args = append(args, output.URL)
 
-	if debug {
-		cmd.Stderr = os.Stderr
-		fmt.Println("Running command: " + cmd.String())
-	}
+	cmd := exec.Command(options.ffmpeg)
+	cmd.Args = args
 
-	child_process_manager.ConfigureCommand(cmd)
+	return runOutputCommand(output.Name, cmd, true, options)
+}
 
-	err := cmd.Start()
+func forwardCustom(output OutputConfig, options ProcessOptions, info StreamInfo) error {
+	cmd, err := buildCustomCommand(output, info)
 
 	if err != nil {
-		fmt.Println("Error: ffmpeg program failed: " + err.Error())
-		os.Exit(1)
+		return err
 	}
 
-	child_process_manager.AddChildProcess(cmd.Process)
-
-	setProcess(cmd.Process)
-
-	err = cmd.Wait()
+	return runOutputCommand(output.Name, cmd, false, options)
+}
 
-	if err != nil {
-		fmt.Println("Error: ffmpeg program failed: " + err.Error())
-		os.Exit(1)
+// Runs the command of an output, waiting for it to end
+// monitorProgress - True if the command is FFMpeg, with the '-progress pipe:1' option
+// Returns an error if the command failed
+func runOutputCommand(name string, cmd *exec.Cmd, monitorProgress bool, options ProcessOptions) error {
+	if options.debug {
+		cmd.Stderr = NewRedactingWriter(os.Stderr)
+		fmt.Println("[" + name + "] Running command: " + redactSecrets(cmd.String()))
 	}
 
-	setProcess(nil)
-
-	os.Exit(0)
-}
+	var stdout io.ReadCloser = nil
+	var stdin io.WriteCloser = nil
 
-func forwardCustom(customCommand string, debug bool) {
-	args := strings.Fields(customCommand)
+	if monitorProgress {
+		var err error
+		stdout, err = cmd.StdoutPipe()
 
-	var cmd *exec.Cmd
+		if err != nil {
+			return err
+		}
 
-	if len(args) > 1 {
-		cmd = exec.Command(args[0], args[1:]...)
-	} else {
-		cmd = exec.Command(args[0])
-	}
+		stdin, err = cmd.StdinPipe()
 
-	if debug {
-		cmd.Stderr = os.Stderr
-		fmt.Println("Running command: " + cmd.String())
+		if err != nil {
+			return err
+		}
 	}
 
 	child_process_manager.ConfigureCommand(cmd)
@@ -110,22 +113,51 @@ func forwardCustom(customCommand string, debug bool) {
 	err := cmd.Start()
 
 	if err != nil {
-		fmt.Println("Error: ffmpeg program failed: " + err.Error())
-		os.Exit(1)
+		return err
 	}
 
 	child_process_manager.AddChildProcess(cmd.Process)
 
-	setProcess(cmd.Process)
+	processDone := make(chan bool)
+	defer close(processDone)
+
+	setOutputProcess(name, &OutputProcess{
+		process: cmd.Process,
+		stdin:   stdin,
+		done:    processDone,
+	})
+
+	stalled := false
+	stalledLock := &sync.Mutex{}
+
+	// Closed when the progress output is fully read
+	readDone := make(chan bool)
+
+	if monitorProgress {
+		done := make(chan bool)
+		defer close(done)
+
+		go monitorFFmpegProgress(name, stdout, options.ffmpegProgress, options.debug, done, readDone, func() {
+			stalledLock.Lock()
+			stalled = true
+			stalledLock.Unlock()
+
+			cmd.Process.Kill()
+		})
+
+		// Wait must not be called before the reads from the pipe finish
+		<-readDone
+	}
 
 	err = cmd.Wait()
 
-	if err != nil {
-		fmt.Println("Error: ffmpeg program failed: " + err.Error())
-		os.Exit(1)
+	stalledLock.Lock()
+	if stalled {
+		err = errors.New("output stalled")
 	}
+	stalledLock.Unlock()
 
-	setProcess(nil)
+	setOutputProcess(name, nil)
 
-	os.Exit(0)
+	return err
 }
@@ -0,0 +1,221 @@
+// FFMPEG progress monitoring
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Configuration to monitor the progress of FFMpeg
+type FFmpegProgressConfig struct {
+	// Time without progress to consider the output stalled (0 = disabled)
+	stallTimeout time.Duration
+
+	// Interval to log the progress (0 = disabled)
+	logInterval time.Duration
+}
+
+// Loads the FFMpeg progress configuration from env variables
+func loadFFmpegProgressConfig() FFmpegProgressConfig {
+	config := FFmpegProgressConfig{
+		stallTimeout: 0,
+		logInterval:  0,
+	}
+
+	if os.Getenv("FFMPEG_STALL_TIMEOUT") != "" {
+		stallTimeout, err := strconv.Atoi(os.Getenv("FFMPEG_STALL_TIMEOUT"))
+		if err != nil || stallTimeout < 0 {
+			fmt.Println("Invalid FFMPEG_STALL_TIMEOUT provided. It must be a number of seconds.")
+			os.Exit(1)
+		}
+		config.stallTimeout = time.Duration(stallTimeout) * time.Second
+	}
+
+	if os.Getenv("FFMPEG_PROGRESS_LOG_INTERVAL") != "" {
+		logInterval, err := strconv.Atoi(os.Getenv("FFMPEG_PROGRESS_LOG_INTERVAL"))
+		if err != nil || logInterval < 0 {
+			fmt.Println("Invalid FFMPEG_PROGRESS_LOG_INTERVAL provided. It must be a number of seconds.")
+			os.Exit(1)
+		}
+		config.logInterval = time.Duration(logInterval) * time.Second
+	}
+
+	return config
+}
+
+// Progress reported by FFMpeg
+type FFmpegProgress struct {
+	Frame      int64   `json:"frame"`
+	FPS        float64 `json:"fps"`
+	Bitrate    string  `json:"bitrate"`
+	TotalSize  int64   `json:"total_size"`
+	OutTime    string  `json:"out_time"`
+	DupFrames  int64   `json:"dup_frames"`
+	DropFrames int64   `json:"drop_frames"`
+	Speed      string  `json:"speed"`
+
+	// Timestamp of the last report
+	Updated time.Time `json:"updated"`
+
+	// Timestamp of the last report with the output advancing
+	LastAdvance time.Time `json:"last_advance"`
+}
+
+// Gets a summary of the progress, for the logs
+func (p FFmpegProgress) String() string {
+	return "frame=" + fmt.Sprint(p.Frame) +
+		" fps=" + fmt.Sprint(p.FPS) +
+		" bitrate=" + p.Bitrate +
+		" time=" + p.OutTime +
+		" speed=" + p.Speed +
+		" dup=" + fmt.Sprint(p.DupFrames) +
+		" drop=" + fmt.Sprint(p.DropFrames)
+}
+
+var (
+	outputs_progress_lock = &sync.Mutex{}
+	outputs_progress      = make(map[string]FFmpegProgress)
+)
+
+// Gets the last progress reported by the FFMpeg process of an output
+func getOutputProgress(name string) (FFmpegProgress, bool) {
+	outputs_progress_lock.Lock()
+	defer outputs_progress_lock.Unlock()
+
+	p, ok := outputs_progress[name]
+
+	return p, ok
+}
+
+// Reads the progress of FFMpeg, written with the '-progress' option
+// Calls onProgress each time a progress block is complete
+// Returns when the end of the output is reached
+func readFFmpegProgress(r io.Reader, onProgress func(p FFmpegProgress)) {
+	scanner := bufio.NewScanner(r)
+
+	current := FFmpegProgress{}
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		equalsIndex := strings.Index(line, "=")
+
+		if equalsIndex <= 0 {
+			continue
+		}
+
+		key := line[:equalsIndex]
+		val := strings.TrimSpace(line[equalsIndex+1:])
+
+		switch key {
+		case "frame":
+			current.Frame, _ = strconv.ParseInt(val, 10, 64)
+		case "fps":
+			current.FPS, _ = strconv.ParseFloat(val, 64)
+		case "bitrate":
+			current.Bitrate = val
+		case "total_size":
+			current.TotalSize, _ = strconv.ParseInt(val, 10, 64)
+		case "out_time":
+			current.OutTime = val
+		case "dup_frames":
+			current.DupFrames, _ = strconv.ParseInt(val, 10, 64)
+		case "drop_frames":
+			current.DropFrames, _ = strconv.ParseInt(val, 10, 64)
+		case "speed":
+			current.Speed = val
+		case "progress":
+			// End of the block
+			onProgress(current)
+		}
+	}
+
+	// Keep draining the output if the scanner failed, so FFMpeg does not block
+	io.Copy(io.Discard, r)
+}
+
+// Monitors the progress of the FFMpeg process of an output
+// Calls onStall if the output does not advance for the configured stall timeout
+// Closes readDone when the end of the output is reached
+// Runs until done is closed
+func monitorFFmpegProgress(name string, stdout io.Reader, config FFmpegProgressConfig, debug bool, done chan bool, readDone chan bool, onStall func()) {
+	started := time.Now()
+
+	outputs_progress_lock.Lock()
+	outputs_progress[name] = FFmpegProgress{
+		Updated:     started,
+		LastAdvance: started,
+	}
+	outputs_progress_lock.Unlock()
+
+	live := false
+
+	onProgress := func(p FFmpegProgress) {
+		now := time.Now()
+
+		outputs_progress_lock.Lock()
+
+		prev := outputs_progress[name]
+
+		p.Updated = now
+
+		if p.Frame != prev.Frame || p.TotalSize != prev.TotalSize || p.OutTime != prev.OutTime {
+			p.LastAdvance = now
+		} else {
+			p.LastAdvance = prev.LastAdvance
+		}
+
+		outputs_progress[name] = p
+
+		outputs_progress_lock.Unlock()
+
+		if !live && p.TotalSize > 0 {
+			// The output started writing to the destination
+			live = true
+			emitEvent(EVENT_OUTPUT_LIVE, map[string]string{
+				"output": name,
+			})
+		}
+
+		if debug {
+			fmt.Println("[" + name + "] Progress: " + p.String())
+		}
+	}
+
+	go func() {
+		defer close(readDone)
+		readFFmpegProgress(stdout, onProgress)
+	}()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	lastLog := started
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			p, _ := getOutputProgress(name)
+
+			if config.logInterval > 0 && now.Sub(lastLog) >= config.logInterval {
+				lastLog = now
+				fmt.Println("[" + name + "] Progress: " + p.String())
+			}
+
+			if config.stallTimeout > 0 && now.Sub(p.LastAdvance) >= config.stallTimeout {
+				fmt.Println("Error: [" + name + "] Output stalled. No progress for " + fmt.Sprint(now.Sub(p.LastAdvance).Round(time.Second)))
+				onStall()
+				return
+			}
+		}
+	}
+}
@@ -6,15 +6,34 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v3"
 )
 
-func createForwardSDPFile(fileName string, videoPort int, audioPort int) string {
+// Max number of video packets to hold while waiting for the outputs
+// If the current GOP is longer, it is dropped and a new keyframe is requested
+const FORWARD_GOP_MAX_PACKETS = 512
+
+// Max time to wait for the outputs to listen for the forwarded packets
+const FORWARD_PORT_READY_TIMEOUT = 10 * time.Second
+
+// Interval to check if the outputs are listening
+const FORWARD_PORT_CHECK_INTERVAL = 50 * time.Millisecond
+
+func createForwardSDPFile(fileName string, videoPort int, audioPort int, videoCodec string) string {
 
 	nl := "\n"
 
+	videoRtpMap := "a=rtpmap:96 VP8/90000"
+
+	if strings.EqualFold(videoCodec, webrtc.MimeTypeH264) {
+		videoRtpMap = "a=rtpmap:96 H264/90000" + nl +
+			"a=fmtp:96 packetization-mode=1"
+	}
+
 	sdpFileContents := "v=0" + nl +
 		"o=- 0 0 IN IP4 127.0.0.1" + nl +
 		"s=Pion WebRTC" + nl +
@@ -23,7 +42,7 @@ func createForwardSDPFile(fileName string, videoPort int, audioPort int) string
 		"m=audio " + fmt.Sprint(audioPort) + " RTP/AVP 111" + nl +
 		"a=rtpmap:111 OPUS/48000/2" + nl +
 		"m=video " + fmt.Sprint(videoPort) + " RTP/AVP 96" + nl +
-		"a=rtpmap:96 VP8/90000"
+		videoRtpMap
 
 	err := os.WriteFile(fileName, []byte(sdpFileContents), 0644)
 	if err != nil {
@@ -33,7 +52,9 @@ func createForwardSDPFile(fileName string, videoPort int, audioPort int) string
 	return fileName
 }
 
-func forwardTrack(track *webrtc.TrackRemote, port int) {
+// Forwards the RTP packets of a track to the ports of the outputs
+// Returns when the track ends, or when stop is closed (the track was replaced by another one)
+func forwardTrack(track *webrtc.TrackRemote, ports []int, gate *ForwardGate, keyframeRequester *KeyframeRequester, stop chan bool, jitterBufferLatency time.Duration, debug bool) {
 	// Set payload type
 	var payloadType uint8
 
@@ -43,6 +64,188 @@ func forwardTrack(track *webrtc.TrackRemote, port int) {
 		payloadType = 111
 	}
 
+	// Dial the port of each output
+	conns := make([]*net.UDPConn, len(ports))
+
+	for i, port := range ports {
+		conns[i] = dialForwardPort(port)
+	}
+
+	// Listen for the keyframe requests of the outputs (video only)
+	if keyframeRequester != nil {
+		for _, conn := range conns {
+			go readForwardFeedback(conn, keyframeRequester)
+		}
+	}
+
+	defer func() {
+		for _, conn := range conns {
+			if conn == nil {
+				continue
+			}
+			if closeErr := conn.Close(); closeErr != nil {
+				panic(closeErr)
+			}
+		}
+	}()
+
+	b := make([]byte, 1500)
+
+	isVideo := track.Kind() == webrtc.RTPCodecTypeVideo
+	mimeType := track.Codec().MimeType
+
+	// Video packets since the last keyframe, held until the outputs are listening
+	gop := make([]*rtp.Packet, 0)
+
+	// Forwards a packet, unless the gate is still waiting for a keyframe
+	forward := func(rtpPacket *rtp.Packet) bool {
+		select {
+		case <-stop:
+			return false // Replaced by another track
+		default:
+		}
+
+		if !isVideo {
+			if !gate.IsOpen() {
+				return true // Drop audio before the first keyframe to keep sync
+			}
+
+			return writeForwardPacket(conns, rtpPacket, payloadType, b)
+		}
+
+		if gate.IsOpen() {
+			return writeForwardPacket(conns, rtpPacket, payloadType, b)
+		}
+
+		// Keep the current GOP, starting with the most recent keyframe
+		// The packets of a keyframe share the timestamp (for example, H.264 SPS, PPS and IDR)
+		if isKeyframeStart(mimeType, rtpPacket) && (len(gop) == 0 || gop[0].Timestamp != rtpPacket.Timestamp) {
+			gop = gop[:0]
+		} else if len(gop) == 0 {
+			return true // Not a keyframe yet
+		}
+
+		gop = append(gop, rtpPacket)
+
+		if len(gop) > FORWARD_GOP_MAX_PACKETS {
+			gop = gop[:0]
+
+			if keyframeRequester != nil {
+				keyframeRequester.Request("keyframe buffer full")
+			}
+
+			return true
+		}
+
+		if !gate.IsReady() {
+			return true // Wait for the outputs to listen
+		}
+
+		gate.Open()
+
+		// Flush the GOP, so the outputs start with the keyframe
+		for _, p := range gop {
+			if !writeForwardPacket(conns, p, payloadType, b) {
+				return false
+			}
+		}
+
+		gop = nil
+
+		return true
+	}
+
+	// Requests a keyframe when packets are lost (video only)
+	onPacketLoss := func() {
+		if keyframeRequester != nil {
+			keyframeRequester.OnPacketLoss()
+		}
+	}
+
+	if jitterBufferLatency <= 0 {
+		// No jitter buffer, forward in arrival order
+		var lossDetector *LossDetector = nil
+
+		if keyframeRequester != nil {
+			lossDetector = NewLossDetector(keyframeRequester.policy.lossGrace)
+		}
+
+		for {
+			// Read
+			rtpPacket, _, readErr := track.ReadRTP()
+			if readErr != nil {
+				return
+			}
+
+			// Detect the packets not recovered by the retransmissions
+			if lossDetector != nil && lossDetector.Push(rtpPacket.SequenceNumber, time.Now()) > 0 {
+				onPacketLoss()
+			}
+
+			if !forward(rtpPacket) {
+				return
+			}
+		}
+	}
+
+	jitterBuffer := NewJitterBuffer(jitterBufferLatency)
+	done := make(chan bool)
+	stopped := make(chan bool)
+
+	// Wait for the pop goroutine before closing the connections
+	defer func() {
+		close(done)
+		<-stopped
+	}()
+
+	// Pop the packets from the jitter buffer
+	go func() {
+		ticker := time.NewTicker(JITTER_BUFFER_POP_INTERVAL)
+		defer ticker.Stop()
+		defer close(stopped)
+
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				lostBefore, _ := jitterBuffer.Stats()
+
+				for _, rtpPacket := range jitterBuffer.Pop(now) {
+					if !forward(rtpPacket) {
+						return
+					}
+				}
+
+				if lostAfter, _ := jitterBuffer.Stats(); lostAfter > lostBefore {
+					onPacketLoss()
+				}
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-stopped:
+			return
+		default:
+		}
+
+		// Read
+		rtpPacket, _, readErr := track.ReadRTP()
+		if readErr != nil {
+			return
+		}
+
+		if !jitterBuffer.Push(rtpPacket, time.Now()) && debug {
+			lost, late := jitterBuffer.Stats()
+			fmt.Println("[" + strings.ToUpper(track.Kind().String()) + "] Dropped late packet #" + fmt.Sprint(rtpPacket.SequenceNumber) + " | Lost: " + fmt.Sprint(lost) + " | Late: " + fmt.Sprint(late))
+		}
+	}
+}
+
+// Dials the UDP port to forward the packets to
+func dialForwardPort(port int) *net.UDPConn {
 	// Create a local addr
 	var laddr *net.UDPAddr
 	var err error = nil
@@ -62,30 +265,95 @@ func forwardTrack(track *webrtc.TrackRemote, port int) {
 	if conn, err = net.DialUDP("udp", laddr, raddr); err != nil {
 		panic(err)
 	}
-	defer func(conn net.PacketConn) {
-		if closeErr := conn.Close(); closeErr != nil {
-			panic(closeErr)
+
+	return conn
+}
+
+// Checks if a local UDP port is listening
+// Sends an empty datagram, that is answered with ICMP port unreachable if nothing is listening
+func isForwardPortListening(port int) bool {
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	if err != nil {
+		return false
+	}
+
+	defer conn.Close()
+
+	if _, err = conn.Write([]byte{}); err != nil {
+		return false
+	}
+
+	conn.SetReadDeadline(time.Now().Add(FORWARD_PORT_CHECK_INTERVAL))
+
+	_, err = conn.Read(make([]byte, 1))
+
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true // No ICMP error
+	}
+
+	return err == nil
+}
+
+// Waits for the UDP ports of the outputs to be listening
+// Returns false if they are not listening after the timeout
+func waitForwardPortsListening(ports []int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+
+	for _, port := range ports {
+		if port <= 0 {
+			continue
 		}
-	}(conn)
 
-	b := make([]byte, 1500)
-	rtpPacket := &rtp.Packet{}
-	for {
-		// Read
-		n, _, readErr := track.Read(b)
-		if readErr != nil {
-			return
+		for !isForwardPortListening(port) {
+			if time.Now().After(deadline) {
+				return false
+			}
+
+			time.Sleep(FORWARD_PORT_CHECK_INTERVAL)
 		}
+	}
 
-		// Unmarshal the packet and update the PayloadType
-		if err = rtpPacket.Unmarshal(b[:n]); err != nil {
-			panic(err)
+	return true
+}
+
+// Reads the RTCP packets sent back by an output to the forward connection
+// Runs until the connection is closed
+func readForwardFeedback(conn *net.UDPConn, keyframeRequester *KeyframeRequester) {
+	buf := make([]byte, 1500)
+
+	for {
+		n, err := conn.Read(buf)
+
+		if err != nil {
+			if opError, ok := err.(*net.OpError); ok && opError.Err.Error() == "read: connection refused" {
+				continue // The output is not listening yet
+			}
+
+			return // Closed
 		}
-		rtpPacket.PayloadType = payloadType
 
-		// Marshal into original buffer with updated PayloadType
-		if n, err = rtpPacket.MarshalTo(b); err != nil {
-			panic(err)
+		keyframeRequester.OnOutputFeedback(buf[:n])
+	}
+}
+
+// Writes a RTP packet to the forward UDP connections
+// A connection failing is closed and set to nil, without affecting the rest
+// Returns false if the forwarding must stop (all the connections failed)
+func writeForwardPacket(conns []*net.UDPConn, rtpPacket *rtp.Packet, payloadType uint8, b []byte) bool {
+	// Update the PayloadType
+	rtpPacket.PayloadType = payloadType
+
+	// Marshal into the buffer with updated PayloadType
+	n, err := rtpPacket.MarshalTo(b)
+	if err != nil {
+		panic(err)
+	}
+
+	remaining := 0
+
+	for i, conn := range conns {
+		if conn == nil {
+			continue
 		}
 
 		// Write
@@ -96,11 +364,15 @@ func forwardTrack(track *webrtc.TrackRemote, port int) {
 			// That's why, for this particular example, the user first needs to provide the answer
 			// to the browser then open the third party application. Therefore we must not kill
 			// the forward on "connection refused" errors
-			if opError, ok := err.(*net.OpError); ok && opError.Err.Error() == "write: connection refused" {
+			if opError, ok := err.(*net.OpError); !ok || opError.Err.Error() != "write: connection refused" {
+				conn.Close()
+				conns[i] = nil
 				continue
-			} else {
-				return
 			}
 		}
+
+		remaining++
 	}
+
+	return remaining > 0
 }
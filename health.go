@@ -0,0 +1,304 @@
+// Health and readiness endpoints
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Configuration of the health server
+type HealthServerConfig struct {
+	// Address to listen for HTTP requests (empty = disabled)
+	addr string
+
+	// Max time for the forward to report its status
+	// If exceeded, the forwarder is considered stuck
+	checkTimeout time.Duration
+
+	// Max time without receiving packets of a track to consider it flowing
+	mediaTimeout time.Duration
+
+	debug bool
+}
+
+// Status of the forward, reported by the main loop
+type ForwardStatus struct {
+	// True if connected to the signaling server
+	SignalingConnected bool
+
+	// State of the WebRTC connection (empty if there is no session)
+	WebRTCState string
+
+	// Time of the last packet received for each expected track (zero if none received)
+	Tracks map[string]time.Time
+}
+
+// Status of an output
+type OutputStatus struct {
+	Name string `json:"name"`
+
+	// True if the process of the output is running
+	Running bool `json:"running"`
+
+	// Number of times the output was restarted after failing
+	Restarts int `json:"restarts"`
+
+	// Last progress reported by FFMpeg (nil if not available)
+	Progress *FFmpegProgress `json:"progress"`
+}
+
+var (
+	health_lock            = &sync.Mutex{}
+	health_config          = HealthServerConfig{}
+	health_status_provider func() ForwardStatus
+)
+
+// Loads the health server configuration from env variables,
+// and starts listening if enabled
+func loadHealthServerConfig(debug bool) {
+	config := HealthServerConfig{
+		addr:         os.Getenv("HEALTH_ADDR"),
+		checkTimeout: 5 * time.Second,
+		mediaTimeout: 10 * time.Second,
+		debug:        debug,
+	}
+
+	if os.Getenv("HEALTH_CHECK_TIMEOUT") != "" {
+		checkTimeout, err := strconv.Atoi(os.Getenv("HEALTH_CHECK_TIMEOUT"))
+		if err != nil || checkTimeout <= 0 {
+			fmt.Println("Invalid HEALTH_CHECK_TIMEOUT provided. It must be a number of seconds.")
+			os.Exit(1)
+		}
+		config.checkTimeout = time.Duration(checkTimeout) * time.Second
+	}
+
+	if os.Getenv("HEALTH_MEDIA_TIMEOUT") != "" {
+		mediaTimeout, err := strconv.Atoi(os.Getenv("HEALTH_MEDIA_TIMEOUT"))
+		if err != nil || mediaTimeout <= 0 {
+			fmt.Println("Invalid HEALTH_MEDIA_TIMEOUT provided. It must be a number of seconds.")
+			os.Exit(1)
+		}
+		config.mediaTimeout = time.Duration(mediaTimeout) * time.Second
+	}
+
+	health_lock.Lock()
+	health_config = config
+	health_lock.Unlock()
+
+	if config.addr == "" {
+		return
+	}
+
+	listener, err := net.Listen("tcp", config.addr)
+	if err != nil {
+		fmt.Println("Error: Could not listen for health checks on " + config.addr + ": " + err.Error())
+		os.Exit(1)
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/healthz", handleHealthCheck)
+	mux.HandleFunc("/readyz", handleReadinessCheck)
+	mux.HandleFunc("/status", handleStatus)
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	fmt.Println("Listening for health checks on " + listener.Addr().String())
+
+	go func() {
+		err := server.Serve(listener)
+
+		if err != nil && err != http.ErrServerClosed {
+			fmt.Println("Error: Health server failed: " + err.Error())
+		}
+	}()
+}
+
+// Sets the function to get the status of the forward
+func setForwardStatusProvider(provider func() ForwardStatus) {
+	health_lock.Lock()
+	defer health_lock.Unlock()
+
+	health_status_provider = provider
+}
+
+// Gets the status of the forward
+// Returns false if the forward did not report its status before the timeout
+func getForwardStatus(timeout time.Duration) (ForwardStatus, bool) {
+	health_lock.Lock()
+	provider := health_status_provider
+	health_lock.Unlock()
+
+	if provider == nil {
+		return ForwardStatus{}, true // Not started yet
+	}
+
+	result := make(chan ForwardStatus, 1)
+
+	go func() {
+		result <- provider()
+	}()
+
+	select {
+	case status := <-result:
+		return status, true
+	case <-time.After(timeout):
+		return ForwardStatus{}, false
+	}
+}
+
+// Sends the response of a health check
+func sendHealthResponse(w http.ResponseWriter, ok bool, status string, checks map[string]bool) {
+	res := struct {
+		Status string          `json:"status"`
+		Checks map[string]bool `json:"checks"`
+	}{
+		Status: status,
+		Checks: checks,
+	}
+
+	body, err := json.Marshal(res)
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if ok {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	w.Write(body)
+}
+
+// Liveness check
+// Fails if the main loop does not respond
+func handleHealthCheck(w http.ResponseWriter, r *http.Request) {
+	health_lock.Lock()
+	config := health_config
+	health_lock.Unlock()
+
+	_, responsive := getForwardStatus(config.checkTimeout)
+
+	checks := map[string]bool{
+		"responsive": responsive,
+	}
+
+	if !responsive {
+		if config.debug {
+			fmt.Println("[HEALTH] The forward did not report its status in " + fmt.Sprint(config.checkTimeout))
+		}
+
+		sendHealthResponse(w, false, "stuck", checks)
+		return
+	}
+
+	sendHealthResponse(w, true, "ok", checks)
+}
+
+// Readiness check
+// Fails unless the source is connected, the media is flowing and the outputs are running
+func handleReadinessCheck(w http.ResponseWriter, r *http.Request) {
+	health_lock.Lock()
+	config := health_config
+	health_lock.Unlock()
+
+	if isShuttingDown() {
+		sendHealthResponse(w, false, "shutting_down", map[string]bool{})
+		return
+	}
+
+	status, responsive := getForwardStatus(config.checkTimeout)
+
+	now := time.Now()
+
+	tracksFlowing := len(status.Tracks) > 0
+
+	for _, lastPacket := range status.Tracks {
+		if lastPacket.IsZero() || now.Sub(lastPacket) > config.mediaTimeout {
+			tracksFlowing = false
+		}
+	}
+
+	running, total := getOutputsRunning()
+
+	checks := map[string]bool{
+		"responsive": responsive,
+		"signaling":  status.SignalingConnected,
+		"webrtc":     status.WebRTCState == "connected",
+		"tracks":     tracksFlowing,
+		"outputs":    running >= total,
+	}
+
+	for _, ok := range checks {
+		if !ok {
+			sendHealthResponse(w, false, "not_ready", checks)
+			return
+		}
+	}
+
+	sendHealthResponse(w, true, "ready", checks)
+}
+
+// Status of the forward, for monitoring
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	outputs := make([]OutputStatus, 0)
+
+	for _, name := range getOutputNames() {
+		status := OutputStatus{
+			Name:     name,
+			Running:  isOutputRunning(name),
+			Restarts: getOutputRestarts(name),
+			Progress: nil,
+		}
+
+		if progress, ok := getOutputProgress(name); ok {
+			status.Progress = &progress
+		}
+
+		outputs = append(outputs, status)
+	}
+
+	// Last statistics of the WebRTC session (nil if not collected)
+	var webrtcStats *WebRTCStats = nil
+
+	if s, ok := getWebRTCStats(); ok {
+		webrtcStats = &s
+	}
+
+	res := struct {
+		Outputs []OutputStatus `json:"outputs"`
+		WebRTC  *WebRTCStats   `json:"webrtc"`
+	}{
+		Outputs: outputs,
+		WebRTC:  webrtcStats,
+	}
+
+	body, err := json.Marshal(res)
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	w.Write([]byte(redactSecrets(string(body))))
+}
@@ -0,0 +1,119 @@
+// Lifecycle hooks
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Configuration of the lifecycle hooks
+type HooksConfig struct {
+	// Hook command for each event
+	commands map[string]string
+
+	// Max time for a hook to run
+	timeout time.Duration
+
+	debug bool
+}
+
+var hooks_config = HooksConfig{
+	commands: make(map[string]string),
+	timeout:  10 * time.Second,
+	debug:    false,
+}
+
+// Loads the hooks configuration from env variables
+// For each event, the command is set in HOOK_<EVENT>
+// Example: HOOK_OUTPUT_STARTED
+func loadHooksConfig(debug bool) {
+	hooks_config.debug = debug
+
+	for _, event := range LIFECYCLE_EVENTS {
+		envName := "HOOK_" + strings.ToUpper(event)
+		command := os.Getenv(envName)
+
+		if command == "" {
+			continue
+		}
+
+		if _, err := splitCommandLine(command); err != nil {
+			fmt.Println("Invalid " + envName + " provided: " + err.Error())
+			os.Exit(1)
+		}
+
+		hooks_config.commands[event] = command
+	}
+
+	if os.Getenv("HOOK_TIMEOUT") != "" {
+		timeout, err := strconv.Atoi(os.Getenv("HOOK_TIMEOUT"))
+		if err != nil || timeout <= 0 {
+			fmt.Println("Invalid HOOK_TIMEOUT provided. It must be a number of seconds.")
+			os.Exit(1)
+		}
+		hooks_config.timeout = time.Duration(timeout) * time.Second
+	}
+}
+
+// Checks if there is a hook for an event
+func hasHook(event string) bool {
+	return hooks_config.commands[event] != ""
+}
+
+// Runs the hook command of an event
+// The event is passed as JSON to the standard input,
+// and as FORWARDER_* environment variables
+func runHook(e LifecycleEvent) {
+	args, err := splitCommandLine(hooks_config.commands[e.Event])
+
+	if err != nil {
+		fmt.Println("Error: [HOOK] " + e.Event + ": " + err.Error())
+		return
+	}
+
+	eventJSON, err := json.Marshal(e)
+
+	if err != nil {
+		fmt.Println("Error: [HOOK] " + e.Event + ": " + err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), hooks_config.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+
+	cmd.Stdin = bytes.NewReader(eventJSON)
+
+	cmd.Env = append(os.Environ(),
+		"FORWARDER_EVENT="+e.Event,
+		"FORWARDER_STREAM_ID="+e.StreamId,
+		"FORWARDER_TIMESTAMP="+fmt.Sprint(e.Timestamp),
+	)
+
+	for key, val := range e.Details {
+		cmd.Env = append(cmd.Env, "FORWARDER_"+strings.ToUpper(key)+"="+val)
+	}
+
+	if hooks_config.debug {
+		cmd.Stdout = NewRedactingWriter(os.Stdout)
+		cmd.Stderr = NewRedactingWriter(os.Stderr)
+		fmt.Println("[HOOK] Running hook for event: " + e.Event)
+	}
+
+	err = cmd.Run()
+
+	if ctx.Err() == context.DeadlineExceeded {
+		fmt.Println("Error: [HOOK] " + e.Event + ": Timed out after " + fmt.Sprint(hooks_config.timeout))
+	} else if err != nil {
+		fmt.Println("Error: [HOOK] " + e.Event + ": " + err.Error())
+	}
+}
@@ -0,0 +1,219 @@
+// Jitter buffer
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// Max number of packets the jitter buffer can hold
+// before it starts skipping missing packets
+const JITTER_BUFFER_MAX_PACKETS = 1024
+
+// Max distance (in sequence numbers) of a late packet
+// Packets further behind indicate a jump in the sequence numbers, and the buffer starts over (RFC 3550, A.1)
+const JITTER_BUFFER_MAX_DROPOUT = 3000
+
+// Interval to check for packets ready to be forwarded
+const JITTER_BUFFER_POP_INTERVAL = 5 * time.Millisecond
+
+// Jitter buffer entry
+type jitterBufferEntry struct {
+	packet  *rtp.Packet
+	arrival time.Time
+}
+
+// Jitter buffer to reorder RTP packets by sequence number
+type JitterBuffer struct {
+	lock *sync.Mutex
+
+	latency time.Duration
+
+	packets map[uint16]*jitterBufferEntry
+
+	started bool
+	nextSeq uint16
+
+	lost uint64
+	late uint64
+}
+
+// Creates new jitter buffer
+// latency - Max time to wait for a missing packet (or its retransmission)
+func NewJitterBuffer(latency time.Duration) *JitterBuffer {
+	return &JitterBuffer{
+		lock:    &sync.Mutex{},
+		latency: latency,
+		packets: make(map[uint16]*jitterBufferEntry),
+		started: false,
+		nextSeq: 0,
+		lost:    0,
+		late:    0,
+	}
+}
+
+// Returns true if the sequence number a is before b, taking wrap-around into account
+func seqBefore(a uint16, b uint16) bool {
+	return int16(a-b) < 0
+}
+
+// Adds a packet to the buffer
+// Returns false if the packet was dropped because it arrived too late
+// or it was a duplicate
+func (jb *JitterBuffer) Push(packet *rtp.Packet, arrival time.Time) bool {
+	jb.lock.Lock()
+	defer jb.lock.Unlock()
+
+	seq := packet.SequenceNumber
+
+	if !jb.started {
+		jb.started = true
+		jb.nextSeq = seq
+	}
+
+	if seqBefore(seq, jb.nextSeq) {
+		if jb.nextSeq-seq <= JITTER_BUFFER_MAX_DROPOUT {
+			// The packet was already given up on
+			jb.late++
+			return false
+		}
+
+		// The sequence numbers jumped, start over
+		jb.packets = make(map[uint16]*jitterBufferEntry)
+		jb.nextSeq = seq
+	}
+
+	if jb.packets[seq] != nil {
+		// Duplicate (retransmission of a packet we already have)
+		return false
+	}
+
+	jb.packets[seq] = &jitterBufferEntry{
+		packet:  packet,
+		arrival: arrival,
+	}
+
+	return true
+}
+
+// Finds the buffered packet with the lowest sequence number
+func (jb *JitterBuffer) oldest() (uint16, *jitterBufferEntry) {
+	var oldestSeq uint16
+	var oldestEntry *jitterBufferEntry = nil
+
+	for seq, entry := range jb.packets {
+		if oldestEntry == nil || seqBefore(seq, oldestSeq) {
+			oldestSeq = seq
+			oldestEntry = entry
+		}
+	}
+
+	return oldestSeq, oldestEntry
+}
+
+// Pops the packets ready to be forwarded, in order
+// A gap is skipped when the packet after it has been waiting for longer than the latency
+func (jb *JitterBuffer) Pop(now time.Time) []*rtp.Packet {
+	jb.lock.Lock()
+	defer jb.lock.Unlock()
+
+	result := make([]*rtp.Packet, 0)
+
+	for len(jb.packets) > 0 {
+		entry := jb.packets[jb.nextSeq]
+
+		if entry != nil {
+			delete(jb.packets, jb.nextSeq)
+			result = append(result, entry.packet)
+			jb.nextSeq++
+			continue
+		}
+
+		// Missing packet, check if we should keep waiting for it
+		oldestSeq, oldestEntry := jb.oldest()
+
+		if now.Sub(oldestEntry.arrival) < jb.latency && len(jb.packets) < JITTER_BUFFER_MAX_PACKETS {
+			break
+		}
+
+		// Give up on the missing packets
+		jb.lost += uint64(oldestSeq - jb.nextSeq)
+		jb.nextSeq = oldestSeq
+	}
+
+	return result
+}
+
+// Gets the number of packets given up as lost
+// and the number of packets dropped for arriving too late
+func (jb *JitterBuffer) Stats() (lost uint64, late uint64) {
+	jb.lock.Lock()
+	defer jb.lock.Unlock()
+
+	return jb.lost, jb.late
+}
+
+// Detects lost packets of a stream forwarded in arrival order
+// A missing packet is considered lost after a grace period, so the retransmissions (NACK) have time to arrive
+// Not safe for concurrent use
+type LossDetector struct {
+	grace time.Duration
+
+	started bool
+	highest uint16
+
+	// Missing sequence numbers, with the time the gap was detected
+	missing map[uint16]time.Time
+}
+
+// Creates new loss detector
+// grace - Time to wait for a missing packet before considering it lost
+func NewLossDetector(grace time.Duration) *LossDetector {
+	return &LossDetector{
+		grace:   grace,
+		started: false,
+		highest: 0,
+		missing: make(map[uint16]time.Time),
+	}
+}
+
+// Registers a received packet
+// Returns the number of missing packets given up as lost
+func (d *LossDetector) Push(seq uint16, now time.Time) int {
+	if !d.started {
+		d.started = true
+		d.highest = seq
+	} else if seqBefore(d.highest, seq) {
+		if seq-d.highest > JITTER_BUFFER_MAX_DROPOUT {
+			// The sequence numbers jumped, start over
+			d.missing = make(map[uint16]time.Time)
+		} else {
+			for s := d.highest + 1; s != seq && len(d.missing) < JITTER_BUFFER_MAX_PACKETS; s++ {
+				d.missing[s] = now
+			}
+		}
+
+		d.highest = seq
+	} else if d.highest-seq > JITTER_BUFFER_MAX_DROPOUT {
+		// The sequence numbers jumped, start over
+		d.missing = make(map[uint16]time.Time)
+		d.highest = seq
+	} else {
+		// Reordered or retransmitted packet
+		delete(d.missing, seq)
+	}
+
+	lost := 0
+
+	for s, detected := range d.missing {
+		if now.Sub(detected) >= d.grace {
+			delete(d.missing, s)
+			lost++
+		}
+	}
+
+	return lost
+}
@@ -0,0 +1,192 @@
+// Keyframe detection
+
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// Checks if a RTP packet is the start of a keyframe
+// mimeType - Mime type of the track codec
+func isKeyframeStart(mimeType string, packet *rtp.Packet) bool {
+	switch strings.ToLower(mimeType) {
+	case strings.ToLower(webrtc.MimeTypeVP8):
+		return isVP8KeyframeStart(packet.Payload)
+	case strings.ToLower(webrtc.MimeTypeH264):
+		return isH264KeyframeStart(packet.Payload)
+	default:
+		return false
+	}
+}
+
+// Checks if a VP8 RTP payload is the start of a keyframe
+// See: https://datatracker.ietf.org/doc/html/rfc7741#section-4.2
+func isVP8KeyframeStart(payload []byte) bool {
+	if len(payload) < 1 {
+		return false
+	}
+
+	// Start of partition, with partition index 0
+	if payload[0]&0x10 == 0 || payload[0]&0x07 != 0 {
+		return false
+	}
+
+	offset := 1
+
+	if payload[0]&0x80 != 0 {
+		// Extended control bits
+		if len(payload) <= offset {
+			return false
+		}
+
+		ext := payload[offset]
+		offset++
+
+		if ext&0x80 != 0 {
+			// Picture ID
+			if len(payload) <= offset {
+				return false
+			}
+
+			if payload[offset]&0x80 != 0 {
+				offset += 2
+			} else {
+				offset++
+			}
+		}
+
+		if ext&0x40 != 0 {
+			// TL0PICIDX
+			offset++
+		}
+
+		if ext&0x20 != 0 || ext&0x10 != 0 {
+			// TID / KEYIDX
+			offset++
+		}
+	}
+
+	if len(payload) <= offset {
+		return false
+	}
+
+	// Inverse key frame flag in the VP8 payload header
+	return payload[offset]&0x01 == 0
+}
+
+// Checks if a H.264 RTP payload is the start of a keyframe
+// See: https://datatracker.ietf.org/doc/html/rfc6184#section-5.2
+func isH264KeyframeStart(payload []byte) bool {
+	if len(payload) < 1 {
+		return false
+	}
+
+	nalType := payload[0] & 0x1F
+
+	switch nalType {
+	case 5, 7:
+		// IDR slice or SPS
+		return true
+	case 24:
+		// STAP-A
+		offset := 1
+		for offset+2 < len(payload) {
+			size := int(payload[offset])<<8 | int(payload[offset+1])
+			offset += 2
+
+			if offset >= len(payload) {
+				return false
+			}
+
+			innerType := payload[offset] & 0x1F
+
+			if innerType == 5 || innerType == 7 {
+				return true
+			}
+
+			offset += size
+		}
+		return false
+	case 28:
+		// FU-A
+		if len(payload) < 2 {
+			return false
+		}
+
+		innerType := payload[1] & 0x1F
+		isStart := payload[1]&0x80 != 0
+
+		return isStart && (innerType == 5 || innerType == 7)
+	default:
+		return false
+	}
+}
+
+// Gate to hold the forwarding until a keyframe is received
+// and the outputs are listening for the packets
+type ForwardGate struct {
+	lock *sync.Mutex
+
+	open  bool
+	ready bool
+
+	onOpen func()
+}
+
+// Creates new forward gate
+// waitKeyframe - True to wait for a video keyframe, false to open it right away
+// onOpen - Function called when the gate opens
+func NewForwardGate(waitKeyframe bool, onOpen func()) *ForwardGate {
+	return &ForwardGate{
+		lock:   &sync.Mutex{},
+		open:   !waitKeyframe,
+		ready:  false,
+		onOpen: onOpen,
+	}
+}
+
+// Checks if the gate is open
+func (g *ForwardGate) IsOpen() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	return g.open
+}
+
+// Marks the outputs as listening, so the gate can open with the next keyframe
+func (g *ForwardGate) SetReady() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.ready = true
+}
+
+// Checks if the outputs are listening
+func (g *ForwardGate) IsReady() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	return g.ready
+}
+
+// Opens the gate
+func (g *ForwardGate) Open() {
+	g.lock.Lock()
+
+	if g.open {
+		g.lock.Unlock()
+		return
+	}
+
+	g.open = true
+
+	g.lock.Unlock()
+
+	if g.onOpen != nil {
+		g.onOpen()
+	}
+}
@@ -0,0 +1,242 @@
+// Keyframe requests
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+)
+
+// Policy to request keyframes to the publisher
+type KeyframePolicy struct {
+	// Use FIR instead of PLI
+	useFIR bool
+
+	// Min time between keyframe requests
+	minInterval time.Duration
+
+	// Interval to request keyframes periodically (0 = disabled)
+	periodicInterval time.Duration
+
+	// Request a keyframe when packets are lost
+	onPacketLoss bool
+
+	// Time to wait for a missing packet to be retransmitted (NACK) before considering it lost
+	lossGrace time.Duration
+}
+
+// Loads the keyframe request policy from env variables
+func loadKeyframePolicy() KeyframePolicy {
+	policy := KeyframePolicy{
+		useFIR:           false,
+		minInterval:      500 * time.Millisecond,
+		periodicInterval: 0,
+		onPacketLoss:     os.Getenv("KEYFRAME_ON_PACKET_LOSS") != "NO",
+		lossGrace:        300 * time.Millisecond,
+	}
+
+	method := strings.ToUpper(os.Getenv("KEYFRAME_REQUEST_METHOD"))
+
+	if method == "FIR" {
+		policy.useFIR = true
+	} else if method != "" && method != "PLI" {
+		fmt.Println("Invalid KEYFRAME_REQUEST_METHOD provided. It can be: PLI or FIR")
+		os.Exit(1)
+	}
+
+	if os.Getenv("KEYFRAME_MIN_INTERVAL") != "" {
+		minInterval, err := strconv.Atoi(os.Getenv("KEYFRAME_MIN_INTERVAL"))
+		if err != nil || minInterval < 0 {
+			fmt.Println("Invalid KEYFRAME_MIN_INTERVAL provided. It must be a number of milliseconds.")
+			os.Exit(1)
+		}
+		policy.minInterval = time.Duration(minInterval) * time.Millisecond
+	}
+
+	if os.Getenv("KEYFRAME_PERIODIC_INTERVAL") != "" {
+		periodicInterval, err := strconv.Atoi(os.Getenv("KEYFRAME_PERIODIC_INTERVAL"))
+		if err != nil || periodicInterval < 0 {
+			fmt.Println("Invalid KEYFRAME_PERIODIC_INTERVAL provided. It must be a number of milliseconds.")
+			os.Exit(1)
+		}
+		policy.periodicInterval = time.Duration(periodicInterval) * time.Millisecond
+	}
+
+	if os.Getenv("KEYFRAME_LOSS_GRACE") != "" {
+		lossGrace, err := strconv.Atoi(os.Getenv("KEYFRAME_LOSS_GRACE"))
+		if err != nil || lossGrace < 0 {
+			fmt.Println("Invalid KEYFRAME_LOSS_GRACE provided. It must be a number of milliseconds.")
+			os.Exit(1)
+		}
+		policy.lossGrace = time.Duration(lossGrace) * time.Millisecond
+	}
+
+	return policy
+}
+
+// Sends keyframe requests for a video track, following a policy
+type KeyframeRequester struct {
+	lock *sync.Mutex
+
+	peerConnection *webrtc.PeerConnection
+	ssrc           uint32
+
+	policy KeyframePolicy
+
+	lastRequest time.Time
+	pending     bool
+	firSeq      uint8
+
+	closed bool
+
+	debug bool
+}
+
+// Creates new keyframe requester
+func NewKeyframeRequester(peerConnection *webrtc.PeerConnection, ssrc uint32, policy KeyframePolicy, debug bool) *KeyframeRequester {
+	return &KeyframeRequester{
+		lock:           &sync.Mutex{},
+		peerConnection: peerConnection,
+		ssrc:           ssrc,
+		policy:         policy,
+		lastRequest:    time.Time{},
+		pending:        false,
+		firSeq:         0,
+		closed:         false,
+		debug:          debug,
+	}
+}
+
+// Requests a keyframe
+// If a request was sent less than the min interval ago,
+// it is delayed until the interval is over
+func (kr *KeyframeRequester) Request(reason string) {
+	kr.lock.Lock()
+	defer kr.lock.Unlock()
+
+	if kr.closed || kr.pending {
+		return
+	}
+
+	elapsed := time.Since(kr.lastRequest)
+
+	if elapsed < kr.policy.minInterval {
+		kr.pending = true
+
+		time.AfterFunc(kr.policy.minInterval-elapsed, func() {
+			kr.lock.Lock()
+			defer kr.lock.Unlock()
+
+			kr.pending = false
+
+			if !kr.closed {
+				kr.send(reason)
+			}
+		})
+
+		return
+	}
+
+	kr.send(reason)
+}
+
+// Requests a keyframe after packet loss, if enabled by the policy
+func (kr *KeyframeRequester) OnPacketLoss() {
+	if !kr.policy.onPacketLoss {
+		return
+	}
+
+	kr.Request("packet loss")
+}
+
+// Handles the RTCP packets sent back by an output
+// Requests a keyframe if the output asks for it (PLI or FIR)
+func (kr *KeyframeRequester) OnOutputFeedback(raw []byte) {
+	packets, err := rtcp.Unmarshal(raw)
+
+	if err != nil {
+		return // Not RTCP
+	}
+
+	for _, packet := range packets {
+		switch packet.(type) {
+		case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+			kr.Request("output request")
+			return
+		}
+	}
+}
+
+// Sends the keyframe request
+// Must be called with the lock acquired
+func (kr *KeyframeRequester) send(reason string) {
+	kr.lastRequest = time.Now()
+
+	var packet rtcp.Packet
+	var packetName string
+
+	if kr.policy.useFIR {
+		kr.firSeq++
+		packet = &rtcp.FullIntraRequest{
+			MediaSSRC: kr.ssrc,
+			FIR: []rtcp.FIREntry{
+				{
+					SSRC:           kr.ssrc,
+					SequenceNumber: kr.firSeq,
+				},
+			},
+		}
+		packetName = "FIR"
+	} else {
+		packet = &rtcp.PictureLossIndication{
+			MediaSSRC: kr.ssrc,
+		}
+		packetName = "PLI"
+	}
+
+	if kr.debug {
+		fmt.Println("[VIDEO] Requesting keyframe (" + packetName + ") | Reason: " + reason)
+	}
+
+	if rtcpErr := kr.peerConnection.WriteRTCP([]rtcp.Packet{packet}); rtcpErr != nil {
+		fmt.Println(rtcpErr)
+	}
+}
+
+// Requests keyframes periodically, if enabled by the policy
+// Runs until the requester is closed
+func (kr *KeyframeRequester) RunPeriodic() {
+	if kr.policy.periodicInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(kr.policy.periodicInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		kr.lock.Lock()
+		closed := kr.closed
+		kr.lock.Unlock()
+
+		if closed {
+			return
+		}
+
+		kr.Request("periodic")
+	}
+}
+
+// Closes the requester, so no more requests are sent
+func (kr *KeyframeRequester) Close() {
+	kr.lock.Lock()
+	defer kr.lock.Unlock()
+
+	kr.closed = true
+}
@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"os"
 	"strconv"
+	"time"
 
 	child_process_manager "github.com/AgustinSRG/go-child-process-manager"
 )
@@ -41,7 +42,7 @@ func main() {
 	portVideo := 0
 	sdpFile := ""
 	forwardMode := ""
-	forwardParam := ""
+	outputsConfigFile := ""
 
 	source := ""
 
@@ -78,6 +79,13 @@ func main() {
 			}
 			forwardMode = args[i+1]
 			i++
+		} else if arg == "--outputs-config" || arg == "-oc" {
+			if i == len(args)-3 {
+				fmt.Println("The option '--outputs-config' requires a value")
+				os.Exit(1)
+			}
+			outputsConfigFile = args[i+1]
+			i++
 		} else if arg == "--auth" || arg == "-a" {
 			if i == len(args)-3 {
 				fmt.Println("The option '--auth' requires a value")
@@ -124,49 +132,101 @@ func main() {
 		os.Exit(1)
 	}
 
-	if portVideo == 0 {
-		fmt.Println("Missing required option: --video-port")
-		os.Exit(1)
+	if authSecret == "" {
+		authSecret = getSecretEnv("AUTH_SECRET")
 	}
 
-	if portAudio == 0 {
-		fmt.Println("Missing required option: --video-audio")
-		os.Exit(1)
-	}
+	registerSecret(authSecret)
+	registerSecret(authToken)
+	registerURLSecrets(source)
 
-	if portAudio == portVideo {
-		fmt.Println("Port for video cannot be the same as the port for audio")
-		os.Exit(1)
+	outputs := make([]OutputConfig, 0)
+
+	if outputsConfigFile == "" || forwardMode != "" {
+		if portVideo == 0 {
+			fmt.Println("Missing required option: --video-port")
+			os.Exit(1)
+		}
+
+		if portAudio == 0 {
+			fmt.Println("Missing required option: --video-audio")
+			os.Exit(1)
+		}
+
+		if portAudio == portVideo {
+			fmt.Println("Port for video cannot be the same as the port for audio")
+			os.Exit(1)
+		}
+
+		if sdpFile == "" {
+			fmt.Println("Missing required option: --sdp-file")
+			os.Exit(1)
+		}
+
+		if forwardMode == "" {
+			fmt.Println("Missing required option: --forward-mode")
+			os.Exit(1)
+		}
+
+		if forwardMode != "TEST" && forwardMode != "RTMP" && forwardMode != "CUSTOM" {
+			fmt.Println("Invalid forward mode: " + forwardMode)
+			os.Exit(1)
+		}
+
+		mainOutput := OutputConfig{
+			Name:      "main",
+			Mode:      forwardMode,
+			VideoPort: portVideo,
+			AudioPort: portAudio,
+			SDPFile:   sdpFile,
+		}
+
+		if forwardMode == "RTMP" {
+			mainOutput.URL = getSecretEnv("RTMP_FORWARD_URL")
+			uSource, err := url.Parse(mainOutput.URL)
+			if err != nil || (uSource.Scheme != "rtmp" && uSource.Scheme != "rtmps") {
+				fmt.Println("Invalid RTMP URL provided. Please set RTMP_FORWARD_URL to a valid URL when usinmg RTMP forward mode.")
+				os.Exit(1)
+			}
+		} else if forwardMode == "CUSTOM" {
+			mainOutput.Command = getSecretEnv("CUSTOM_FORWARD_COMMAND")
+			if mainOutput.Command == "" {
+				fmt.Println("Please set CUSTOM_FORWARD_COMMAND when using CUSTOM forward mode.")
+				os.Exit(1)
+			}
+		}
+
+		outputs = append(outputs, mainOutput)
 	}
 
-	if sdpFile == "" {
-		fmt.Println("Missing required option: --sdp-file")
-		os.Exit(1)
+	if outputsConfigFile != "" {
+		fileOutputs, err := loadOutputsConfigFile(outputsConfigFile)
+		if err != nil {
+			fmt.Println("Error: Could not load outputs configuration file: " + err.Error())
+			os.Exit(1)
+		}
+
+		outputs = append(outputs, fileOutputs...)
 	}
 
-	if forwardMode == "" {
-		fmt.Println("Missing required option: --forward-mode")
+	if err := validateOutputsConfig(outputs); err != nil {
+		fmt.Println("Invalid outputs configuration: " + redactSecrets(err.Error()))
 		os.Exit(1)
 	}
 
-	if forwardMode != "TEST" && forwardMode != "RTMP" && forwardMode != "CUSTOM" {
-		fmt.Println("Invalid forward mode: " + forwardMode)
-		os.Exit(1)
+	for _, output := range outputs {
+		registerURLSecrets(output.URL)
 	}
 
-	if forwardMode == "RTMP" {
-		forwardParam = os.Getenv("RTMP_FORWARD_URL")
-		uSource, err := url.Parse(forwardParam)
-		if err != nil || (uSource.Scheme != "rtmp" && uSource.Scheme != "rtmps") {
-			fmt.Println("Invalid RTMP URL provided. Please set RTMP_FORWARD_URL to a valid URL when usinmg RTMP forward mode.")
-			os.Exit(1)
-		}
-	} else if forwardMode == "CUSTOM" {
-		forwardParam = os.Getenv("CUSTOM_FORWARD_COMMAND")
-		if forwardParam == "" {
-			fmt.Println("Please set CUSTOM_FORWARD_COMMAND when using CUSTOM forward mode.")
+	jitterBufferLatency := 0
+
+	if os.Getenv("JITTER_BUFFER_LATENCY") != "" {
+		jbl, err := strconv.Atoi(os.Getenv("JITTER_BUFFER_LATENCY"))
+		if err != nil || jbl < 0 {
+			fmt.Println("Invalid JITTER_BUFFER_LATENCY provided. It must be a number of milliseconds.")
 			os.Exit(1)
 		}
+		jitterBufferLatency = jbl
 	}
 
 	uSource, err := url.Parse(source)
@@ -186,21 +246,33 @@ func main() {
 		os.Exit(1)
 	}
 
-	wsURLSource := url.URL{
-		Scheme: protocolSource,
-		Host:   hostSource,
-		Path:   "/ws",
+	signalingPath := os.Getenv("SIGNALING_PATH")
+
+	if signalingPath == "" {
+		signalingPath = "/ws"
+	} else if signalingPath[0] != '/' {
+		signalingPath = "/" + signalingPath
 	}
 
-	if authSecret != "" {
-		authToken = generateToken(authSecret, streamIdSource)
+	wsURLSource := url.URL{
+		Scheme:   protocolSource,
+		Host:     hostSource,
+		Path:     signalingPath,
+		RawQuery: uSource.RawQuery,
 	}
 
+	auth := loadAuthTokenProvider(authToken, authSecret, streamIdSource)
+
 	if _, err := os.Stat(ffmpegPath); err != nil {
 		fmt.Println("Error: Could not find 'ffmpeg' at specified location: " + ffmpegPath)
 		os.Exit(1)
 	}
 
+	initEvents(streamIdSource)
+	loadHooksConfig(debug)
+	loadWebhookConfig(debug)
+	loadHealthServerConfig(debug)
+
 	err = child_process_manager.InitializeChildProcessManager()
 	if err != nil {
 		fmt.Println("Error: " + err.Error())
@@ -208,16 +280,38 @@ func main() {
 	}
 	defer child_process_manager.DisposeChildProcessManager()
 
-	runProcess(wsURLSource, streamIdSource, ProcessOptions{
-		debug:        debug,
-		portAudio:    portAudio,
-		portVideo:    portVideo,
-		sdpFile:      sdpFile,
-		ffmpeg:       ffmpegPath,
-		forwardMode:  forwardMode,
-		forwardParam: forwardParam,
-		authToken:    authToken,
+	initShutdown()
+
+	sourceSignaling := NewWebRTCCDNSignaling(wsURLSource, streamIdSource, auth, loadSignalingLivenessConfig(), loadSignalingConnectionConfig(), debug)
+
+	runProcess(sourceSignaling, streamIdSource, ProcessOptions{
+		debug:  debug,
+		ffmpeg: ffmpegPath,
+
+		outputs: outputs,
+
+		jitterBufferLatency: time.Duration(jitterBufferLatency) * time.Millisecond,
+
+		keyframePolicy: loadKeyframePolicy(),
+
+		ffmpegProgress: loadFFmpegProgressConfig(),
+
+		outputRestart: loadOutputRestartPolicy(),
+
+		reconnect: loadSourceReconnectPolicy(),
+
+		iceRestart: loadICERestartPolicy(),
+
+		webrtcConfig: loadWebRTCConfig(),
+
+		webrtcSettings: loadWebRTCSettingEngine(),
+
+		webrtcStats: loadWebRTCStatsConfig(),
+
+		mediaInactivity: loadMediaInactivityPolicy(),
 	})
+
+	waitForShutdown()
 }
 
 func printHelp() {
@@ -229,11 +323,12 @@ func printHelp() {
 	fmt.Println("        --input, -i <SOURCE>                    Input WebRTC stream. Example: ws(s)://host:port/stream-id")
 	fmt.Println("        --sdp-file, -sdp <file>                 File where to print the SDP description.")
 	fmt.Println("        --forward-mode, -fm <MODE>              Forward mode can be: TEST, RTMP or CUSTOM.")
+	fmt.Println("        --outputs-config, -oc <file>            JSON file with extra outputs to forward the stream to.")
 	fmt.Println("        --video-port, -vp <port>                Sets the port for video packets.")
 	fmt.Println("        --audio-port, -ap <port>                Sets the port for audio packets.")
 	fmt.Println("        --ffmpeg-path <path>                    Sets FFMpeg path.")
 	fmt.Println("        --auth, -a <auth-token>                 Sets authentication token for the source.")
-	fmt.Println("        --secret, -s <secret>                   Sets secret to generate authentication tokens.")
+	fmt.Println("        --secret, -s <secret>                   Sets secret to generate authentication tokens. Prefer AUTH_SECRET_FILE.")
 	fmt.Println("    FORWARD MODES:")
 	fmt.Println("        --forward-mode TEST                     Creates the SDP file and does nothing else. For testing.")
 	fmt.Println("        --forward-mode RTMP                     Forwards the RTC stream to RTMP. Set RTMP_FORWARD_URL env variable.")
@@ -0,0 +1,148 @@
+// Media inactivity detection
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pion/interceptor/pkg/stats"
+	"github.com/pion/webrtc/v3"
+)
+
+// Actions when a track is inactive
+const (
+	MEDIA_INACTIVITY_ACTION_KEYFRAME  = "KEYFRAME"
+	MEDIA_INACTIVITY_ACTION_RECONNECT = "RECONNECT"
+	MEDIA_INACTIVITY_ACTION_END       = "END"
+)
+
+// Interval to check the activity of the tracks
+const MEDIA_INACTIVITY_CHECK_INTERVAL = time.Second
+
+// Policy to detect tracks without media
+// The WebRTC connection can stay connected while the publisher stops sending packets
+type MediaInactivityPolicy struct {
+	// Time without receiving packets to consider a track inactive (0 = disabled)
+	timeout time.Duration
+
+	// Action when a track becomes inactive
+	action string
+}
+
+// Loads the media inactivity policy from env variables
+func loadMediaInactivityPolicy() MediaInactivityPolicy {
+	policy := MediaInactivityPolicy{
+		timeout: 0,
+		action:  MEDIA_INACTIVITY_ACTION_KEYFRAME,
+	}
+
+	if os.Getenv("MEDIA_INACTIVITY_TIMEOUT") != "" {
+		timeout, err := strconv.Atoi(os.Getenv("MEDIA_INACTIVITY_TIMEOUT"))
+		if err != nil || timeout < 0 {
+			fmt.Println("Invalid MEDIA_INACTIVITY_TIMEOUT provided. It must be a number of seconds.")
+			os.Exit(1)
+		}
+		policy.timeout = time.Duration(timeout) * time.Second
+	}
+
+	if os.Getenv("MEDIA_INACTIVITY_ACTION") != "" {
+		switch strings.ToUpper(os.Getenv("MEDIA_INACTIVITY_ACTION")) {
+		case MEDIA_INACTIVITY_ACTION_KEYFRAME:
+			policy.action = MEDIA_INACTIVITY_ACTION_KEYFRAME
+		case MEDIA_INACTIVITY_ACTION_RECONNECT:
+			policy.action = MEDIA_INACTIVITY_ACTION_RECONNECT
+		case MEDIA_INACTIVITY_ACTION_END:
+			policy.action = MEDIA_INACTIVITY_ACTION_END
+		default:
+			fmt.Println("Invalid MEDIA_INACTIVITY_ACTION provided. It can be 'KEYFRAME', 'RECONNECT' or 'END'.")
+			os.Exit(1)
+		}
+	}
+
+	return policy
+}
+
+// Limits the consecutive new offers requested because of inactive tracks
+// Unlike the ICE restarts, the attempts are not reset when the connection succeeds,
+// since a publisher with frozen media still connects. They are reset when packets are received.
+// Not safe for concurrent use
+type InactivityRestartBudget struct {
+	maxAttempts int
+	attempts    int
+}
+
+// Creates new budget for the restarts because of inactive tracks
+// maxAttempts - Max number of consecutive restarts
+func NewInactivityRestartBudget(maxAttempts int) *InactivityRestartBudget {
+	return &InactivityRestartBudget{
+		maxAttempts: maxAttempts,
+		attempts:    0,
+	}
+}
+
+// Uses an attempt to restart the session
+// Returns false if there are no attempts left
+func (b *InactivityRestartBudget) Use() bool {
+	if b.attempts >= b.maxAttempts {
+		return false
+	}
+
+	b.attempts++
+
+	return true
+}
+
+// Resets the attempts, because packets were received
+func (b *InactivityRestartBudget) Reset() {
+	b.attempts = 0
+}
+
+// Monitors the packets received for a track
+// getter - Stats of the RTP streams, from the stats interceptor
+// onActive - Called when the first packet of the track is received
+// onInactive - Called when the track becomes inactive
+// onResumed - Called when packets are received again, after being inactive
+// Runs until stop is closed
+func monitorTrackActivity(track *webrtc.TrackRemote, getter stats.Getter, policy MediaInactivityPolicy, stop chan bool, onActive func(), onInactive func(idle time.Duration), onResumed func()) {
+	if policy.timeout <= 0 || getter == nil {
+		return
+	}
+
+	started := time.Now()
+	active := false
+	inactive := false
+
+	ticker := time.NewTicker(MEDIA_INACTIVITY_CHECK_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			lastPacket := getTrackLastPacket(getter, track)
+
+			if lastPacket.Before(started) {
+				// No packets yet
+				lastPacket = started
+			} else if !active {
+				active = true
+				onActive()
+			}
+
+			idle := now.Sub(lastPacket)
+
+			if !inactive && idle >= policy.timeout {
+				inactive = true
+				onInactive(idle)
+			} else if inactive && idle < policy.timeout {
+				inactive = false
+				onResumed()
+			}
+		}
+	}
+}
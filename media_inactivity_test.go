@@ -0,0 +1,57 @@
+// Tests for the media inactivity detection
+
+package main
+
+import (
+	"testing"
+)
+
+func TestInactivityRestartBudgetConnectedWithoutMedia(t *testing.T) {
+	budget := NewInactivityRestartBudget(3)
+
+	// Each new session connects, but the media stays inactive
+	restarts := 0
+
+	for session := 0; session < 10; session++ {
+		if !budget.Use() {
+			break
+		}
+
+		restarts++
+	}
+
+	if restarts != 3 {
+		t.Fatalf("Unexpected number of restarts: %d (expected 3)", restarts)
+	}
+
+	if budget.Use() {
+		t.Fatal("The budget must stay exhausted until packets are received")
+	}
+}
+
+func TestInactivityRestartBudgetMediaResumed(t *testing.T) {
+	budget := NewInactivityRestartBudget(2)
+
+	if !budget.Use() || !budget.Use() {
+		t.Fatal("Expected 2 attempts")
+	}
+
+	if budget.Use() {
+		t.Fatal("Expected no attempts left")
+	}
+
+	// Packets received in the new session
+	budget.Reset()
+
+	if !budget.Use() {
+		t.Fatal("Expected the attempts to be reset after receiving packets")
+	}
+}
+
+func TestInactivityRestartBudgetDisabled(t *testing.T) {
+	budget := NewInactivityRestartBudget(0)
+
+	if budget.Use() {
+		t.Fatal("No restarts are allowed with ICE_RESTART_ATTEMPTS=0")
+	}
+}
@@ -0,0 +1,336 @@
+// Forward outputs
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// Output configuration
+type OutputConfig struct {
+	// Name of the output, to identify it in the logs
+	Name string `json:"name"`
+
+	// Forward mode: TEST, RTMP, FFMPEG or CUSTOM
+	Mode string `json:"mode"`
+
+	// Destination URL (RTMP and FFMPEG modes)
+	URL string `json:"url"`
+
+	// Output format (FFMPEG mode)
+	Format string `json:"format"`
+
+	// Extra FFMpeg output arguments, for encoding settings (RTMP and FFMPEG modes)
+	Args []string `json:"args"`
+
+	// Command to run (CUSTOM mode)
+	Command string `json:"command"`
+
+	// Port for video packets
+	VideoPort int `json:"video_port"`
+
+	// Port for audio packets
+	AudioPort int `json:"audio_port"`
+
+	// File where to write the SDP description
+	SDPFile string `json:"sdp_file"`
+}
+
+// Outputs configuration file
+type OutputsConfigFile struct {
+	Outputs []OutputConfig `json:"outputs"`
+}
+
+// Loads outputs from a JSON configuration file
+func loadOutputsConfigFile(file string) ([]OutputConfig, error) {
+	b, err := os.ReadFile(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config := OutputsConfigFile{}
+
+	err = json.Unmarshal(b, &config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range config.Outputs {
+		if config.Outputs[i].Name == "" {
+			config.Outputs[i].Name = "output-" + fmt.Sprint(i+1)
+		}
+	}
+
+	return config.Outputs, nil
+}
+
+// Validates the configuration of the outputs
+func validateOutputsConfig(outputs []OutputConfig) error {
+	if len(outputs) == 0 {
+		return errors.New("no outputs configured")
+	}
+
+	names := make(map[string]bool)
+	ports := make(map[int]string)
+	sdpFiles := make(map[string]string)
+
+	for _, output := range outputs {
+		if names[output.Name] {
+			return errors.New("duplicated output name: " + output.Name)
+		}
+		names[output.Name] = true
+
+		switch output.Mode {
+		case "TEST":
+		case "RTMP":
+			u, err := url.Parse(output.URL)
+			if err != nil || (u.Scheme != "rtmp" && u.Scheme != "rtmps") {
+				return errors.New("[" + output.Name + "] invalid RTMP URL")
+			}
+		case "FFMPEG":
+			if output.URL == "" {
+				return errors.New("[" + output.Name + "] missing destination URL")
+			}
+		case "CUSTOM":
+			if _, err := splitCommandLine(output.Command); err != nil {
+				return errors.New("[" + output.Name + "] invalid command: " + err.Error())
+			}
+		default:
+			return errors.New("[" + output.Name + "] invalid forward mode: " + output.Mode)
+		}
+
+		if output.VideoPort <= 0 || output.AudioPort <= 0 {
+			return errors.New("[" + output.Name + "] missing video or audio port")
+		}
+
+		if output.VideoPort == output.AudioPort {
+			return errors.New("[" + output.Name + "] port for video cannot be the same as the port for audio")
+		}
+
+		// FFMpeg also binds the next port of each RTP port for RTCP
+		for _, port := range []int{output.VideoPort, output.AudioPort} {
+			for _, p := range []int{port, port + 1} {
+				if other, ok := ports[p]; ok {
+					return errors.New("[" + output.Name + "] port " + fmt.Sprint(p) + " (RTP port " + fmt.Sprint(port) + " or its RTCP port) is already used by output " + other)
+				}
+				ports[p] = output.Name
+			}
+		}
+
+		if output.SDPFile == "" {
+			return errors.New("[" + output.Name + "] missing SDP file")
+		}
+
+		if other, ok := sdpFiles[output.SDPFile]; ok {
+			return errors.New("[" + output.Name + "] SDP file is already used by output " + other)
+		}
+		sdpFiles[output.SDPFile] = output.Name
+	}
+
+	return nil
+}
+
+// Gets the video ports of the outputs
+func getOutputsVideoPorts(outputs []OutputConfig) []int {
+	ports := make([]int, len(outputs))
+
+	for i, output := range outputs {
+		ports[i] = output.VideoPort
+	}
+
+	return ports
+}
+
+// Gets the audio ports of the outputs
+func getOutputsAudioPorts(outputs []OutputConfig) []int {
+	ports := make([]int, len(outputs))
+
+	for i, output := range outputs {
+		ports[i] = output.AudioPort
+	}
+
+	return ports
+}
+
+// Running process of an output
+type OutputProcess struct {
+	process *os.Process
+
+	// Standard input of FFMpeg, to ask it to finish (nil for custom commands)
+	stdin io.WriteCloser
+
+	// Closed when the process ends
+	done chan bool
+}
+
+// Asks the process to finish cleanly, killing it if it does not finish before the timeout
+func (p *OutputProcess) Stop(timeout time.Duration) {
+	if p.stdin != nil {
+		// FFMpeg finishes when 'q' is received
+		_, err := p.stdin.Write([]byte("q"))
+		if err != nil {
+			p.process.Signal(os.Interrupt)
+		}
+	} else {
+		err := p.process.Signal(os.Interrupt)
+		if err != nil {
+			p.process.Kill()
+		}
+	}
+
+	select {
+	case <-p.done:
+	case <-time.After(timeout):
+		p.process.Kill()
+	}
+}
+
+var (
+	outputs_lock      = &sync.Mutex{}
+	outputs_running   = make(map[string]*OutputProcess)
+	outputs_names     = make([]string, 0)
+	outputs_remaining = 0
+	outputs_failed    = false
+)
+
+// Registers the outputs that run a process
+// The program exits when all of them have ended
+func registerOutputs(outputs []OutputConfig) {
+	outputs_lock.Lock()
+	defer outputs_lock.Unlock()
+
+	for _, output := range outputs {
+		outputs_names = append(outputs_names, output.Name)
+	}
+
+	outputs_remaining += len(getProcessOutputs(outputs))
+}
+
+// Gets the outputs that run a process
+// TEST outputs only write the SDP file, for the user to start the process
+func getProcessOutputs(outputs []OutputConfig) []OutputConfig {
+	result := make([]OutputConfig, 0, len(outputs))
+
+	for _, output := range outputs {
+		if output.Mode != "TEST" {
+			result = append(result, output)
+		}
+	}
+
+	return result
+}
+
+// Sets the running process of an output
+func setOutputProcess(name string, p *OutputProcess) {
+	outputs_lock.Lock()
+	defer outputs_lock.Unlock()
+
+	if p != nil {
+		outputs_running[name] = p
+	} else {
+		delete(outputs_running, name)
+	}
+}
+
+// Gets the names of the outputs
+func getOutputNames() []string {
+	outputs_lock.Lock()
+	defer outputs_lock.Unlock()
+
+	return append([]string{}, outputs_names...)
+}
+
+// Checks if an output has a running process
+func isOutputRunning(name string) bool {
+	outputs_lock.Lock()
+	defer outputs_lock.Unlock()
+
+	return outputs_running[name] != nil
+}
+
+// Gets the number of outputs with a running process,
+// and the number of outputs that should be running one
+func getOutputsRunning() (int, int) {
+	outputs_lock.Lock()
+	defer outputs_lock.Unlock()
+
+	return len(outputs_running), outputs_remaining
+}
+
+// Stops all the running outputs
+// Waits for them to finish cleanly, up to the timeout
+func stopOutputs(timeout time.Duration) {
+	outputs_lock.Lock()
+
+	running := make([]*OutputProcess, 0, len(outputs_running))
+
+	for _, p := range outputs_running {
+		running = append(running, p)
+	}
+
+	outputs_lock.Unlock()
+
+	wg := &sync.WaitGroup{}
+
+	for _, p := range running {
+		wg.Add(1)
+		go func(p *OutputProcess) {
+			defer wg.Done()
+			p.Stop(timeout)
+		}(p)
+	}
+
+	wg.Wait()
+}
+
+// Called when an output ends
+// The other outputs are not affected, unless it was the last one
+func onOutputEnded(name string, err error) {
+	outputs_lock.Lock()
+	defer outputs_lock.Unlock()
+
+	delete(outputs_running, name)
+
+	if err != nil {
+		fmt.Println("Error: [" + name + "] Output failed: " + redactSecrets(err.Error()))
+		outputs_failed = true
+	} else {
+		fmt.Println("[" + name + "] Output ended")
+	}
+
+	outputs_remaining--
+
+	if outputs_remaining > 0 {
+		return
+	}
+
+	if outputs_failed {
+		go shutdown(1)
+	} else {
+		go shutdown(0)
+	}
+}
+
+// Runs an output, waiting for it to end
+// Returns an error if the output failed
+func runOutput(output OutputConfig, options ProcessOptions, info StreamInfo) error {
+	switch output.Mode {
+	case "RTMP":
+		return forwardToRTMP(output, options)
+	case "FFMPEG":
+		return forwardFFmpeg(output, options)
+	case "CUSTOM":
+		return forwardCustom(output, options, info)
+	default:
+		return nil
+	}
+}
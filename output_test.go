@@ -0,0 +1,57 @@
+// Tests for the outputs
+
+package main
+
+import (
+	"testing"
+)
+
+func TestProcessOutputsMixed(t *testing.T) {
+	outputs_lock.Lock()
+	outputs_remaining = 0
+	outputs_lock.Unlock()
+
+	outputs := []OutputConfig{
+		{Name: "live", Mode: "RTMP", URL: "rtmp://localhost/live/key", VideoPort: 5000, AudioPort: 5002, SDPFile: "live.sdp"},
+		{Name: "test", Mode: "TEST", VideoPort: 5004, AudioPort: 5006, SDPFile: "test.sdp"},
+	}
+
+	if err := validateOutputsConfig(outputs); err != nil {
+		t.Fatalf("Invalid configuration: %v", err)
+	}
+
+	registerOutputs(outputs)
+
+	// Only the RTMP output is supervised, so it is the only one that can end
+	supervised := getProcessOutputs(outputs)
+
+	if len(supervised) != 1 || supervised[0].Name != "live" {
+		t.Fatalf("Unexpected supervised outputs: %#v", supervised)
+	}
+
+	running, remaining := getOutputsRunning()
+
+	if running != 0 || remaining != len(supervised) {
+		t.Fatalf("Unexpected outputs count. Running: %d | Remaining: %d (expected %d)", running, remaining, len(supervised))
+	}
+}
+
+func TestProcessOutputsOnlyTest(t *testing.T) {
+	outputs_lock.Lock()
+	outputs_remaining = 0
+	outputs_lock.Unlock()
+
+	outputs := []OutputConfig{
+		{Name: "main", Mode: "TEST", VideoPort: 5000, AudioPort: 5002, SDPFile: "main.sdp"},
+	}
+
+	registerOutputs(outputs)
+
+	if supervised := getProcessOutputs(outputs); len(supervised) != 0 {
+		t.Fatalf("TEST outputs must not be supervised: %#v", supervised)
+	}
+
+	if _, remaining := getOutputsRunning(); remaining != 0 {
+		t.Fatalf("Unexpected remaining outputs: %d", remaining)
+	}
+}
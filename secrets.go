@@ -0,0 +1,180 @@
+// Secrets handling and redaction
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Text replacing the secrets in the logs
+const REDACTED = "[REDACTED]"
+
+// Min length of a secret to be redacted
+// Shorter values would redact unrelated parts of the logs
+const SECRET_MIN_LENGTH = 4
+
+// Max length of a line for the redacting writer
+const REDACTING_WRITER_MAX_LINE = 64 * 1024
+
+// Known secrets, to redact them from the logs
+var (
+	secrets_lock = &sync.Mutex{}
+	secrets_list = make([]string, 0)
+)
+
+// Registers a secret, so it is redacted from the logs
+func registerSecret(secret string) {
+	if len(secret) < SECRET_MIN_LENGTH {
+		return
+	}
+
+	secrets_lock.Lock()
+	defer secrets_lock.Unlock()
+
+	for _, s := range secrets_list {
+		if s == secret {
+			return
+		}
+	}
+
+	secrets_list = append(secrets_list, secret)
+
+	// Longer secrets first, in case a secret contains another one
+	sort.Slice(secrets_list, func(i, j int) bool {
+		return len(secrets_list[i]) > len(secrets_list[j])
+	})
+}
+
+// Registers the secret parts of an URL: the password, the query values
+// and, for RTMP URLs, the stream key (last element of the path)
+func registerURLSecrets(rawURL string) {
+	u, err := url.Parse(rawURL)
+
+	if err != nil {
+		return
+	}
+
+	if password, ok := u.User.Password(); ok {
+		registerSecret(password)
+	}
+
+	for _, values := range u.Query() {
+		for _, val := range values {
+			registerSecret(val)
+		}
+	}
+
+	if u.Scheme == "rtmp" || u.Scheme == "rtmps" {
+		path := strings.TrimSuffix(u.Path, "/")
+
+		if i := strings.LastIndex(path, "/"); i > 0 {
+			registerSecret(path[i+1:])
+		}
+	}
+}
+
+// Replaces the known secrets in a text
+func redactSecrets(text string) string {
+	secrets_lock.Lock()
+	defer secrets_lock.Unlock()
+
+	for _, s := range secrets_list {
+		text = strings.ReplaceAll(text, s, REDACTED)
+	}
+
+	return text
+}
+
+// Gets the value of a secret from an env variable
+// If <name>_FILE is set, the value is read from that file instead,
+// so the secret is not visible in the environment of the process
+// The value is registered to be redacted from the logs
+func getSecretEnv(name string) string {
+	value := os.Getenv(name)
+	file := os.Getenv(name + "_FILE")
+
+	if file != "" {
+		if value != "" {
+			fmt.Println("Only one of " + name + " and " + name + "_FILE can be set.")
+			os.Exit(1)
+		}
+
+		content, err := os.ReadFile(file)
+
+		if err != nil {
+			fmt.Println("Error: Could not read " + name + "_FILE: " + err.Error())
+			os.Exit(1)
+		}
+
+		value = strings.TrimRight(string(content), "\r\n")
+	}
+
+	registerSecret(value)
+
+	return value
+}
+
+// Writer that redacts the secrets before writing
+// The output is written line by line, so secrets are not split
+type RedactingWriter struct {
+	lock *sync.Mutex
+
+	out io.Writer
+
+	buf []byte
+}
+
+// Creates a writer that redacts the secrets
+// out - Writer to send the redacted output
+func NewRedactingWriter(out io.Writer) *RedactingWriter {
+	return &RedactingWriter{
+		lock: &sync.Mutex{},
+		out:  out,
+		buf:  make([]byte, 0),
+	}
+}
+
+func (w *RedactingWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.buf = append(w.buf, p...)
+
+	for {
+		i := bytes.IndexAny(w.buf, "\r\n")
+
+		if i < 0 {
+			break
+		}
+
+		line := redactSecrets(string(w.buf[:i+1]))
+		w.buf = w.buf[i+1:]
+
+		_, err := w.out.Write([]byte(line))
+
+		if err != nil {
+			return len(p), err
+		}
+	}
+
+	if len(w.buf) > REDACTING_WRITER_MAX_LINE {
+		// Line too long, write it anyway
+		line := redactSecrets(string(w.buf))
+		w.buf = w.buf[:0]
+
+		_, err := w.out.Write([]byte(line))
+
+		if err != nil {
+			return len(p), err
+		}
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,120 @@
+// Graceful shutdown
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Default max time for an output to finish after being asked to stop
+const DEFAULT_OUTPUT_STOP_TIMEOUT = 10 * time.Second
+
+var (
+	shutdown_lock     = &sync.Mutex{}
+	shutdown_started  = false
+	shutdown_handlers = make([]func(), 0)
+	shutdown_timeout  = DEFAULT_OUTPUT_STOP_TIMEOUT
+	shutdown_files    = make(map[string]bool)
+)
+
+// Loads the shutdown configuration from env variables
+// and starts listening for termination signals
+func initShutdown() {
+	if os.Getenv("OUTPUT_STOP_TIMEOUT") != "" {
+		timeout, err := strconv.Atoi(os.Getenv("OUTPUT_STOP_TIMEOUT"))
+		if err != nil || timeout < 0 {
+			fmt.Println("Invalid OUTPUT_STOP_TIMEOUT provided. It must be a number of seconds.")
+			os.Exit(1)
+		}
+		shutdown_timeout = time.Duration(timeout) * time.Second
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+
+		fmt.Println("Received signal: " + sig.String() + " | Shutting down")
+
+		go shutdown(0)
+
+		// A second signal forces the exit
+		secondSig := <-signals
+
+		fmt.Println("Received signal: " + secondSig.String() + " | Forcing exit")
+
+		os.Exit(1)
+	}()
+}
+
+// Adds a function to be called when the forwarder shuts down,
+// before the outputs are stopped
+func onShutdown(handler func()) {
+	shutdown_lock.Lock()
+	defer shutdown_lock.Unlock()
+
+	shutdown_handlers = append(shutdown_handlers, handler)
+}
+
+// Registers a temporary file, to be removed when the forwarder shuts down
+func addTemporaryFile(file string) {
+	shutdown_lock.Lock()
+	defer shutdown_lock.Unlock()
+
+	shutdown_files[file] = true
+}
+
+// Checks if the forwarder is shutting down
+func isShuttingDown() bool {
+	shutdown_lock.Lock()
+	defer shutdown_lock.Unlock()
+
+	return shutdown_started
+}
+
+// Shuts down the forwarder and exits the program
+// Only the first call has effect
+func shutdown(exitCode int) {
+	shutdown_lock.Lock()
+
+	if shutdown_started {
+		shutdown_lock.Unlock()
+		return
+	}
+
+	shutdown_started = true
+
+	handlers := shutdown_handlers
+	files := shutdown_files
+
+	shutdown_lock.Unlock()
+
+	// Close the source
+	for _, handler := range handlers {
+		handler()
+	}
+
+	// Stop the outputs
+	stopOutputs(shutdown_timeout)
+
+	// Remove temporary files
+	for file := range files {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			fmt.Println("Error: " + err.Error())
+		}
+	}
+
+	endForward(exitCode)
+}
+
+// Blocks until the forwarder shuts down
+func waitForShutdown() {
+	select {}
+}
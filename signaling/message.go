@@ -0,0 +1,192 @@
+// Signaling messages
+
+package signaling
+
+import (
+	"errors"
+	"strings"
+)
+
+// Max size of a signaling message
+const MAX_MESSAGE_SIZE = 1024 * 1024
+
+// Message parameter
+type Param struct {
+	Key   string
+	Value string
+}
+
+// Signaling message
+// Format:
+//
+//	METHOD
+//	Key: Value
+//	...
+//
+//	Body
+type Message struct {
+	// Method, in upper case
+	Method string
+
+	// Parameters, in order
+	Params []Param
+
+	// Body
+	Body string
+}
+
+// Creates new message
+func NewMessage(method string) *Message {
+	return &Message{
+		Method: strings.ToUpper(method),
+		Params: make([]Param, 0),
+		Body:   "",
+	}
+}
+
+// Gets the value of a parameter (case insensitive)
+// Returns an empty string if not found
+func (m *Message) Get(key string) string {
+	for _, p := range m.Params {
+		if strings.EqualFold(p.Key, key) {
+			return p.Value
+		}
+	}
+
+	return ""
+}
+
+// Sets the value of a parameter (case insensitive)
+// Replaces the value if the parameter already exists
+func (m *Message) Set(key string, value string) {
+	for i, p := range m.Params {
+		if strings.EqualFold(p.Key, key) {
+			m.Params[i].Value = value
+			return
+		}
+	}
+
+	m.Params = append(m.Params, Param{Key: key, Value: value})
+}
+
+// Checks if a method name is valid
+func isValidMethod(method string) bool {
+	if method == "" {
+		return false
+	}
+
+	for _, c := range method {
+		if !((c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-' || c == '_') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Checks if a parameter key is valid
+func isValidParamKey(key string) bool {
+	if key == "" {
+		return false
+	}
+
+	for _, c := range key {
+		if !((c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '_') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Checks if a parameter value is valid
+// Values cannot contain line breaks, since they would break the format,
+// and they cannot start or end with spaces, since they are trimmed when parsing
+func isValidParamValue(value string) bool {
+	return !strings.ContainsAny(value, "\r\n") && strings.Trim(value, " \t") == value
+}
+
+// Parses a signaling message
+// Returns an error if the message is malformed
+func Parse(raw string) (*Message, error) {
+	if len(raw) > MAX_MESSAGE_SIZE {
+		return nil, errors.New("message too large")
+	}
+
+	lines := strings.Split(raw, "\n")
+
+	msg := NewMessage(strings.Trim(lines[0], " \r\t"))
+
+	if !isValidMethod(msg.Method) {
+		return nil, errors.New("invalid method: " + msg.Method)
+	}
+
+	for i := 1; i < len(lines); i++ {
+		line := strings.TrimSuffix(lines[i], "\r")
+
+		if line == "" {
+			// Found empty line, the rest is the body
+			msg.Body = strings.Join(lines[i+1:], "\n")
+			break
+		}
+
+		colonIndex := strings.Index(line, ":")
+
+		if colonIndex < 0 {
+			return nil, errors.New("invalid parameter line: missing colon")
+		}
+
+		key := strings.Trim(line[0:colonIndex], " \t")
+		val := strings.Trim(line[colonIndex+1:], " \t")
+
+		if !isValidParamKey(key) {
+			return nil, errors.New("invalid parameter key: " + key)
+		}
+
+		if !isValidParamValue(val) {
+			return nil, errors.New("invalid value for parameter " + key)
+		}
+
+		msg.Set(key, val)
+	}
+
+	return msg, nil
+}
+
+// Serializes the message in order to send it
+// The parameters are serialized in order, so the result is deterministic
+// Returns an error if the message cannot be serialized
+func (m *Message) Serialize() (string, error) {
+	method := strings.ToUpper(m.Method)
+
+	if !isValidMethod(method) {
+		return "", errors.New("invalid method: " + method)
+	}
+
+	raw := strings.Builder{}
+
+	raw.WriteString(method)
+	raw.WriteString("\n")
+
+	for _, p := range m.Params {
+		if !isValidParamKey(p.Key) {
+			return "", errors.New("invalid parameter key: " + p.Key)
+		}
+
+		if !isValidParamValue(p.Value) {
+			return "", errors.New("invalid value for parameter " + p.Key + ": line breaks and surrounding spaces are not allowed")
+		}
+
+		raw.WriteString(p.Key)
+		raw.WriteString(": ")
+		raw.WriteString(p.Value)
+		raw.WriteString("\n")
+	}
+
+	if m.Body != "" {
+		raw.WriteString("\n")
+		raw.WriteString(m.Body)
+	}
+
+	return raw.String(), nil
+}
@@ -0,0 +1,256 @@
+// Tests for the signaling messages
+
+package signaling
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const testSDP = "v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\nm=video 9 UDP/TLS/RTP/SAVPF 96\r\na=rtpmap:96 VP8/90000\r\n"
+
+func uint16Ptr(v uint16) *uint16 {
+	return &v
+}
+
+func stringPtr(v string) *string {
+	return &v
+}
+
+func TestTypedMessageRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  TypedMessage
+	}{
+		{"play", &Play{RequestID: "1", StreamID: "stream", Auth: "token"}},
+		{"play without auth", &Play{RequestID: "1", StreamID: "stream"}},
+		{"offer", &Offer{RequestID: "1", StreamID: "stream", Description: webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: testSDP}}},
+		{"answer", &Answer{RequestID: "1", StreamID: "stream", Description: webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: testSDP}}},
+		{"candidate", &Candidate{RequestID: "1", StreamID: "stream", Candidate: &webrtc.ICECandidateInit{
+			Candidate:        "candidate:1 1 udp 2130706431 192.168.1.2 50000 typ host",
+			SDPMid:           stringPtr("0"),
+			SDPMLineIndex:    uint16Ptr(0),
+			UsernameFragment: stringPtr("abcd"),
+		}}},
+		{"end of candidates", &Candidate{RequestID: "1", StreamID: "stream"}},
+		{"error", &Error{RequestID: "1", ErrorCode: "NOT_FOUND", ErrorMessage: "Stream not found"}},
+		{"error without request", &Error{ErrorCode: "AUTH_ERROR", ErrorMessage: "Invalid token"}},
+		{"standby", &Standby{RequestID: "1", StreamID: "stream"}},
+		{"close", &Close{RequestID: "1", StreamID: "stream"}},
+		{"close without stream", &Close{RequestID: "1"}},
+		{"heartbeat", &Heartbeat{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			raw, err := Encode(test.msg)
+			if err != nil {
+				t.Fatalf("Encode failed: %v", err)
+			}
+
+			if !strings.HasPrefix(raw, test.msg.Method()+"\n") {
+				t.Fatalf("Encoded message does not start with the method: %q", raw)
+			}
+
+			decoded, err := Decode(raw)
+			if err != nil {
+				t.Fatalf("Decode failed: %v\n%s", err, raw)
+			}
+
+			if !reflect.DeepEqual(decoded, test.msg) {
+				t.Fatalf("Round trip mismatch\nExpected: %#v\nDecoded: %#v", test.msg, decoded)
+			}
+		})
+	}
+}
+
+func TestEncodeInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  TypedMessage
+	}{
+		{"line break in value", &Play{RequestID: "1", StreamID: "stream\nOFFER"}},
+		{"carriage return in value", &Play{RequestID: "1", StreamID: "stream\r"}},
+		{"leading space in value", &Play{RequestID: "1", StreamID: " stream"}},
+		{"trailing tab in value", &Error{ErrorCode: "ERROR", ErrorMessage: "message\t"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			raw, err := Encode(test.msg)
+			if err == nil {
+				t.Fatalf("Expected an error, got: %q", raw)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	msg, err := Parse("play \r\nrequest-id:  1 \r\nStream-ID:stream\r\nX-Extra: a: b\r\n\r\nline 1\r\n\r\nline 2")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if msg.Method != "PLAY" {
+		t.Errorf("Unexpected method: %q", msg.Method)
+	}
+
+	expectedParams := map[string]string{
+		PARAM_REQUEST_ID: "1",
+		PARAM_STREAM_ID:  "stream",
+		"x-extra":        "a: b",
+		PARAM_AUTH:       "",
+	}
+
+	for key, expected := range expectedParams {
+		if val := msg.Get(key); val != expected {
+			t.Errorf("Unexpected value for %s: %q (expected %q)", key, val, expected)
+		}
+	}
+
+	if msg.Body != "line 1\r\n\r\nline 2" {
+		t.Errorf("Unexpected body: %q", msg.Body)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+	}{
+		{"empty", ""},
+		{"invalid method", "PLAY NOW\nStream-ID: stream\n"},
+		{"missing colon", "PLAY\nStream-ID stream\n"},
+		{"empty key", "PLAY\n: stream\n"},
+		{"invalid key", "PLAY\nStream ID: stream\n"},
+		{"carriage return in value", "PLAY\nStream-ID: str\ream\n"},
+		{"too large", "PLAY\n\n" + strings.Repeat("a", MAX_MESSAGE_SIZE)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			msg, err := Parse(test.raw)
+			if err == nil {
+				t.Fatalf("Expected an error, got: %#v", msg)
+			}
+		})
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+	}{
+		{"unknown method", "PUBLISH\nStream-ID: stream\n"},
+		{"play without stream", "PLAY\nRequest-ID: 1\n"},
+		{"offer without body", "OFFER\nRequest-ID: 1\nStream-ID: stream\n"},
+		{"offer with invalid body", "OFFER\nRequest-ID: 1\nStream-ID: stream\n\n{"},
+		{"offer with empty SDP", "OFFER\nRequest-ID: 1\nStream-ID: stream\n\n{\"type\":\"offer\",\"sdp\":\"\"}"},
+		{"offer with an answer", "OFFER\nRequest-ID: 1\nStream-ID: stream\n\n{\"type\":\"answer\",\"sdp\":\"v=0\"}"},
+		{"answer with an offer", "ANSWER\nRequest-ID: 1\nStream-ID: stream\n\n{\"type\":\"offer\",\"sdp\":\"v=0\"}"},
+		{"candidate with invalid body", "CANDIDATE\nRequest-ID: 1\nStream-ID: stream\n\n[]"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			msg, err := Decode(test.raw)
+			if err == nil {
+				t.Fatalf("Expected an error, got: %#v", msg)
+			}
+		})
+	}
+}
+
+func TestDecodeEmptyCandidate(t *testing.T) {
+	msg, err := Decode("CANDIDATE\nRequest-ID: 1\nStream-ID: stream\n\n{\"candidate\":\"\"}")
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	candidate, ok := msg.(*Candidate)
+	if !ok {
+		t.Fatalf("Unexpected message: %#v", msg)
+	}
+
+	if candidate.Candidate != nil {
+		t.Fatalf("Expected the end of the candidates, got: %#v", candidate.Candidate)
+	}
+}
+
+// Adds valid and malformed messages to the seed corpus of a fuzz test
+func addSeedMessages(f *testing.F) {
+	f.Add("PLAY\nRequest-ID: 1\nStream-ID: stream\nAuth: token\n")
+	f.Add("OFFER\nRequest-ID: 1\nStream-ID: stream\n\n{\"type\":\"offer\",\"sdp\":\"v=0\\r\\n\"}")
+	f.Add("ANSWER\r\nRequest-ID: 1\r\nStream-ID: stream\r\n\r\n{\"type\":\"answer\",\"sdp\":\"v=0\\r\\n\"}")
+	f.Add("CANDIDATE\nRequest-ID: 1\nStream-ID: stream\n\n{\"candidate\":\"candidate:1 1 udp 1 127.0.0.1 9 typ host\",\"sdpMid\":\"0\",\"sdpMLineIndex\":0}")
+	f.Add("CANDIDATE\nRequest-ID: 1\nStream-ID: stream\n")
+	f.Add("ERROR\nError-Code: NOT_FOUND\nError-Message: Stream not found\n")
+	f.Add("STANDBY\nRequest-ID: 1\nStream-ID: stream\n")
+	f.Add("close\nrequest-id:1\n")
+	f.Add("HEARTBEAT\n")
+	f.Add("PLAY\nStream-ID: a\r\r\n")
+	f.Add("PLAY\nStream-ID stream\n")
+	f.Add("")
+}
+
+func FuzzParse(f *testing.F) {
+	addSeedMessages(f)
+
+	f.Fuzz(func(t *testing.T, raw string) {
+		msg, err := Parse(raw)
+		if err != nil {
+			return
+		}
+
+		serialized, err := msg.Serialize()
+		if err != nil {
+			t.Fatalf("Serialize failed for a parsed message: %v\n%q", err, raw)
+		}
+
+		if len(serialized) > MAX_MESSAGE_SIZE {
+			return
+		}
+
+		parsed, err := Parse(serialized)
+		if err != nil {
+			t.Fatalf("Parse failed for a serialized message: %v\n%q", err, serialized)
+		}
+
+		if !reflect.DeepEqual(parsed, msg) {
+			t.Fatalf("Round trip mismatch\nExpected: %#v\nParsed: %#v", msg, parsed)
+		}
+	})
+}
+
+func FuzzDecode(f *testing.F) {
+	addSeedMessages(f)
+
+	f.Fuzz(func(t *testing.T, raw string) {
+		msg, err := Decode(raw)
+		if err != nil {
+			return
+		}
+
+		encoded, err := Encode(msg)
+		if err != nil {
+			t.Fatalf("Encode failed for a decoded message: %v\n%q", err, raw)
+		}
+
+		if len(encoded) > MAX_MESSAGE_SIZE {
+			return
+		}
+
+		decoded, err := Decode(encoded)
+		if err != nil {
+			t.Fatalf("Decode failed for an encoded message: %v\n%q", err, encoded)
+		}
+
+		if !reflect.DeepEqual(decoded, msg) {
+			t.Fatalf("Round trip mismatch\nExpected: %#v\nDecoded: %#v", msg, decoded)
+		}
+	})
+}
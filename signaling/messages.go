@@ -0,0 +1,328 @@
+// Typed signaling messages
+
+package signaling
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Signaling methods
+const (
+	METHOD_PLAY      = "PLAY"
+	METHOD_OFFER     = "OFFER"
+	METHOD_ANSWER    = "ANSWER"
+	METHOD_CANDIDATE = "CANDIDATE"
+	METHOD_ERROR     = "ERROR"
+	METHOD_STANDBY   = "STANDBY"
+	METHOD_CLOSE     = "CLOSE"
+	METHOD_HEARTBEAT = "HEARTBEAT"
+)
+
+// Signaling parameters
+const (
+	PARAM_REQUEST_ID    = "Request-ID"
+	PARAM_STREAM_ID     = "Stream-ID"
+	PARAM_AUTH          = "Auth"
+	PARAM_ERROR_CODE    = "Error-Code"
+	PARAM_ERROR_MESSAGE = "Error-Message"
+)
+
+// Typed signaling message
+type TypedMessage interface {
+	// Gets the method of the message
+	Method() string
+
+	// Converts to a raw message
+	ToMessage() (*Message, error)
+}
+
+// Request to play a stream
+type Play struct {
+	RequestID string
+	StreamID  string
+	Auth      string
+}
+
+func (m *Play) Method() string {
+	return METHOD_PLAY
+}
+
+func (m *Play) ToMessage() (*Message, error) {
+	msg := NewMessage(METHOD_PLAY)
+	msg.Set(PARAM_REQUEST_ID, m.RequestID)
+	msg.Set(PARAM_STREAM_ID, m.StreamID)
+	if m.Auth != "" {
+		msg.Set(PARAM_AUTH, m.Auth)
+	}
+	return msg, nil
+}
+
+// WebRTC offer
+type Offer struct {
+	RequestID   string
+	StreamID    string
+	Description webrtc.SessionDescription
+}
+
+func (m *Offer) Method() string {
+	return METHOD_OFFER
+}
+
+func (m *Offer) ToMessage() (*Message, error) {
+	return sessionDescriptionMessage(METHOD_OFFER, m.RequestID, m.StreamID, m.Description)
+}
+
+// WebRTC answer
+type Answer struct {
+	RequestID   string
+	StreamID    string
+	Description webrtc.SessionDescription
+}
+
+func (m *Answer) Method() string {
+	return METHOD_ANSWER
+}
+
+func (m *Answer) ToMessage() (*Message, error) {
+	return sessionDescriptionMessage(METHOD_ANSWER, m.RequestID, m.StreamID, m.Description)
+}
+
+// ICE candidate
+type Candidate struct {
+	RequestID string
+	StreamID  string
+
+	// The candidate, nil to indicate the end of the candidates
+	// The end of the candidates is sent with an empty body, since that is what the
+	// signaling servers already understand; an explicit form would not be compatible.
+	// When received, a candidate with an empty 'candidate' field also means the end of the candidates.
+	Candidate *webrtc.ICECandidateInit
+}
+
+func (m *Candidate) Method() string {
+	return METHOD_CANDIDATE
+}
+
+func (m *Candidate) ToMessage() (*Message, error) {
+	msg := NewMessage(METHOD_CANDIDATE)
+	msg.Set(PARAM_REQUEST_ID, m.RequestID)
+	msg.Set(PARAM_STREAM_ID, m.StreamID)
+
+	if m.Candidate != nil {
+		b, err := json.Marshal(m.Candidate)
+		if err != nil {
+			return nil, err
+		}
+		msg.Body = string(b)
+	}
+
+	return msg, nil
+}
+
+// Error message
+type Error struct {
+	RequestID    string
+	ErrorCode    string
+	ErrorMessage string
+}
+
+func (m *Error) Method() string {
+	return METHOD_ERROR
+}
+
+func (m *Error) ToMessage() (*Message, error) {
+	msg := NewMessage(METHOD_ERROR)
+	if m.RequestID != "" {
+		msg.Set(PARAM_REQUEST_ID, m.RequestID)
+	}
+	msg.Set(PARAM_ERROR_CODE, m.ErrorCode)
+	msg.Set(PARAM_ERROR_MESSAGE, m.ErrorMessage)
+	return msg, nil
+}
+
+// The stream is not being published
+type Standby struct {
+	RequestID string
+	StreamID  string
+}
+
+func (m *Standby) Method() string {
+	return METHOD_STANDBY
+}
+
+func (m *Standby) ToMessage() (*Message, error) {
+	msg := NewMessage(METHOD_STANDBY)
+	msg.Set(PARAM_REQUEST_ID, m.RequestID)
+	msg.Set(PARAM_STREAM_ID, m.StreamID)
+	return msg, nil
+}
+
+// Closes a request
+type Close struct {
+	RequestID string
+	StreamID  string
+}
+
+func (m *Close) Method() string {
+	return METHOD_CLOSE
+}
+
+func (m *Close) ToMessage() (*Message, error) {
+	msg := NewMessage(METHOD_CLOSE)
+	msg.Set(PARAM_REQUEST_ID, m.RequestID)
+	if m.StreamID != "" {
+		msg.Set(PARAM_STREAM_ID, m.StreamID)
+	}
+	return msg, nil
+}
+
+// Heartbeat to keep the connection alive
+type Heartbeat struct{}
+
+func (m *Heartbeat) Method() string {
+	return METHOD_HEARTBEAT
+}
+
+func (m *Heartbeat) ToMessage() (*Message, error) {
+	return NewMessage(METHOD_HEARTBEAT), nil
+}
+
+// Creates a message with a session description as body
+func sessionDescriptionMessage(method string, requestId string, streamId string, sd webrtc.SessionDescription) (*Message, error) {
+	b, err := json.Marshal(sd)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := NewMessage(method)
+	msg.Set(PARAM_REQUEST_ID, requestId)
+	msg.Set(PARAM_STREAM_ID, streamId)
+	msg.Body = string(b)
+
+	return msg, nil
+}
+
+// Parses the session description in the body of a message
+func parseSessionDescription(msg *Message) (webrtc.SessionDescription, error) {
+	sd := webrtc.SessionDescription{}
+
+	if msg.Body == "" {
+		return sd, errors.New(msg.Method + ": missing session description")
+	}
+
+	err := json.Unmarshal([]byte(msg.Body), &sd)
+
+	if err != nil {
+		return sd, errors.New(msg.Method + ": invalid session description: " + err.Error())
+	}
+
+	if sd.SDP == "" {
+		return sd, errors.New(msg.Method + ": empty SDP")
+	}
+
+	return sd, nil
+}
+
+// Converts a raw message to a typed message
+// Returns an error if the method is unknown or the message is not valid
+func FromMessage(msg *Message) (TypedMessage, error) {
+	switch msg.Method {
+	case METHOD_PLAY:
+		if msg.Get(PARAM_STREAM_ID) == "" {
+			return nil, errors.New("PLAY: missing " + PARAM_STREAM_ID)
+		}
+		return &Play{
+			RequestID: msg.Get(PARAM_REQUEST_ID),
+			StreamID:  msg.Get(PARAM_STREAM_ID),
+			Auth:      msg.Get(PARAM_AUTH),
+		}, nil
+	case METHOD_OFFER:
+		sd, err := parseSessionDescription(msg)
+		if err != nil {
+			return nil, err
+		}
+		if sd.Type != webrtc.SDPTypeOffer {
+			return nil, errors.New("OFFER: the session description is not an offer")
+		}
+		return &Offer{
+			RequestID:   msg.Get(PARAM_REQUEST_ID),
+			StreamID:    msg.Get(PARAM_STREAM_ID),
+			Description: sd,
+		}, nil
+	case METHOD_ANSWER:
+		sd, err := parseSessionDescription(msg)
+		if err != nil {
+			return nil, err
+		}
+		if sd.Type != webrtc.SDPTypeAnswer {
+			return nil, errors.New("ANSWER: the session description is not an answer")
+		}
+		return &Answer{
+			RequestID:   msg.Get(PARAM_REQUEST_ID),
+			StreamID:    msg.Get(PARAM_STREAM_ID),
+			Description: sd,
+		}, nil
+	case METHOD_CANDIDATE:
+		m := &Candidate{
+			RequestID: msg.Get(PARAM_REQUEST_ID),
+			StreamID:  msg.Get(PARAM_STREAM_ID),
+			Candidate: nil,
+		}
+		if msg.Body != "" {
+			candidate := webrtc.ICECandidateInit{}
+			err := json.Unmarshal([]byte(msg.Body), &candidate)
+			if err != nil {
+				return nil, errors.New("CANDIDATE: invalid candidate: " + err.Error())
+			}
+			if candidate.Candidate != "" {
+				m.Candidate = &candidate
+			}
+		}
+		return m, nil
+	case METHOD_ERROR:
+		return &Error{
+			RequestID:    msg.Get(PARAM_REQUEST_ID),
+			ErrorCode:    msg.Get(PARAM_ERROR_CODE),
+			ErrorMessage: msg.Get(PARAM_ERROR_MESSAGE),
+		}, nil
+	case METHOD_STANDBY:
+		return &Standby{
+			RequestID: msg.Get(PARAM_REQUEST_ID),
+			StreamID:  msg.Get(PARAM_STREAM_ID),
+		}, nil
+	case METHOD_CLOSE:
+		return &Close{
+			RequestID: msg.Get(PARAM_REQUEST_ID),
+			StreamID:  msg.Get(PARAM_STREAM_ID),
+		}, nil
+	case METHOD_HEARTBEAT:
+		return &Heartbeat{}, nil
+	default:
+		return nil, errors.New("unknown method: " + msg.Method)
+	}
+}
+
+// Decodes a signaling message into a typed message
+func Decode(raw string) (TypedMessage, error) {
+	msg, err := Parse(raw)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return FromMessage(msg)
+}
+
+// Encodes a typed message in order to send it
+func Encode(m TypedMessage) (string, error) {
+	msg, err := m.ToMessage()
+
+	if err != nil {
+		return "", err
+	}
+
+	return msg.Serialize()
+}
@@ -0,0 +1,141 @@
+// Source signaling
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Policy to reconnect to the source when the connection is lost
+type SourceReconnectPolicy struct {
+	// Max number of consecutive reconnection attempts (0 = never reconnect, -1 = unlimited)
+	maxAttempts int
+
+	// Delay before each reconnection attempt
+	delay time.Duration
+}
+
+// Loads the source reconnect policy from env variables
+func loadSourceReconnectPolicy() SourceReconnectPolicy {
+	policy := SourceReconnectPolicy{
+		maxAttempts: 0,
+		delay:       2 * time.Second,
+	}
+
+	if os.Getenv("SIGNALING_RECONNECT_ATTEMPTS") != "" {
+		maxAttempts, err := strconv.Atoi(os.Getenv("SIGNALING_RECONNECT_ATTEMPTS"))
+		if err != nil || maxAttempts < -1 {
+			fmt.Println("Invalid SIGNALING_RECONNECT_ATTEMPTS provided. It must be a number of attempts, or -1 for unlimited.")
+			os.Exit(1)
+		}
+		policy.maxAttempts = maxAttempts
+	}
+
+	if os.Getenv("SIGNALING_RECONNECT_DELAY") != "" {
+		delay, err := strconv.Atoi(os.Getenv("SIGNALING_RECONNECT_DELAY"))
+		if err != nil || delay < 0 {
+			fmt.Println("Invalid SIGNALING_RECONNECT_DELAY provided. It must be a number of milliseconds.")
+			os.Exit(1)
+		}
+		policy.delay = time.Duration(delay) * time.Millisecond
+	}
+
+	return policy
+}
+
+// Policy to restart the WebRTC session when the connection is lost
+type ICERestartPolicy struct {
+	// Max number of consecutive restarts (0 = never restart)
+	maxAttempts int
+
+	// Time to wait in disconnected state before restarting
+	delay time.Duration
+}
+
+// Loads the ICE restart policy from env variables
+func loadICERestartPolicy() ICERestartPolicy {
+	policy := ICERestartPolicy{
+		maxAttempts: 3,
+		delay:       2 * time.Second,
+	}
+
+	if os.Getenv("ICE_RESTART_ATTEMPTS") != "" {
+		maxAttempts, err := strconv.Atoi(os.Getenv("ICE_RESTART_ATTEMPTS"))
+		if err != nil || maxAttempts < 0 {
+			fmt.Println("Invalid ICE_RESTART_ATTEMPTS provided. It must be a number of attempts.")
+			os.Exit(1)
+		}
+		policy.maxAttempts = maxAttempts
+	}
+
+	if os.Getenv("ICE_RESTART_DELAY") != "" {
+		delay, err := strconv.Atoi(os.Getenv("ICE_RESTART_DELAY"))
+		if err != nil || delay < 0 {
+			fmt.Println("Invalid ICE_RESTART_DELAY provided. It must be a number of milliseconds.")
+			os.Exit(1)
+		}
+		policy.delay = time.Duration(delay) * time.Millisecond
+	}
+
+	return policy
+}
+
+// Handlers for the events received from the source signaling
+type SourceSignalingHandlers struct {
+	// Called when an offer is received
+	OnOffer func(offer webrtc.SessionDescription)
+
+	// Called when a remote ICE candidate is received
+	// candidate is nil when the remote end has no more candidates
+	OnCandidate func(candidate *webrtc.ICECandidateInit)
+
+	// Called when the source is not publishing
+	OnStandby func()
+}
+
+// Error returned when the source signaling is closed
+type SourceClosedError struct {
+	// Reason of the close
+	Reason string
+
+	// True if the connection was lost, so it may be recovered by reconnecting
+	ConnectionLost bool
+}
+
+func (e *SourceClosedError) Error() string {
+	return e.Reason
+}
+
+// Signaling with the source of the stream
+// Obtains the offer, sends the answer and exchanges the ICE candidates
+type SourceSignaling interface {
+	// Gets a description of the source, for the logs
+	String() string
+
+	// Connects to the source and requests the stream
+	// Can be called again after Run returns, in order to reconnect
+	Connect() error
+
+	// Receives messages from the source, calling the handlers
+	// Blocks until the connection is closed, returning the reason
+	Run(handlers SourceSignalingHandlers) *SourceClosedError
+
+	// Sends the answer to the offer
+	SendAnswer(answer webrtc.SessionDescription) error
+
+	// Sends a local ICE candidate
+	// candidate is nil to indicate there are no more candidates
+	SendCandidate(candidate *webrtc.ICECandidateInit) error
+
+	// Requests a new offer, in order to restart the WebRTC session
+	// The messages of the previous request are ignored from now on
+	RequestOffer() error
+
+	// Closes the connection with the source
+	Close()
+}
@@ -0,0 +1,442 @@
+// Source signaling for webrtc-cdn
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/AgustinSRG/webrtc-forwarder/signaling"
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+)
+
+// Prefix of the request IDs used for the signaling messages
+// A new request ID is used for each request of the stream (play01, play02, ...)
+const SIGNALING_REQUEST_ID_PREFIX = "play"
+
+// Max time to write a message to the websocket
+const SIGNALING_WRITE_TIMEOUT = 10 * time.Second
+
+// Liveness configuration for the signaling connection
+type SignalingLivenessConfig struct {
+	// Interval to send HEARTBEAT messages
+	heartbeatInterval time.Duration
+
+	// Interval to send websocket pings (0 = disabled)
+	pingInterval time.Duration
+
+	// Max time without receiving anything from the server (0 = disabled)
+	timeout time.Duration
+}
+
+// Loads the signaling liveness configuration from env variables
+func loadSignalingLivenessConfig() SignalingLivenessConfig {
+	config := SignalingLivenessConfig{
+		heartbeatInterval: 20 * time.Second,
+		pingInterval:      10 * time.Second,
+		timeout:           60 * time.Second,
+	}
+
+	if os.Getenv("SIGNALING_HEARTBEAT_INTERVAL") != "" {
+		heartbeatInterval, err := strconv.Atoi(os.Getenv("SIGNALING_HEARTBEAT_INTERVAL"))
+		if err != nil || heartbeatInterval <= 0 {
+			fmt.Println("Invalid SIGNALING_HEARTBEAT_INTERVAL provided. It must be a number of seconds.")
+			os.Exit(1)
+		}
+		config.heartbeatInterval = time.Duration(heartbeatInterval) * time.Second
+	}
+
+	if os.Getenv("SIGNALING_PING_INTERVAL") != "" {
+		pingInterval, err := strconv.Atoi(os.Getenv("SIGNALING_PING_INTERVAL"))
+		if err != nil || pingInterval < 0 {
+			fmt.Println("Invalid SIGNALING_PING_INTERVAL provided. It must be a number of seconds.")
+			os.Exit(1)
+		}
+		config.pingInterval = time.Duration(pingInterval) * time.Second
+	}
+
+	if os.Getenv("SIGNALING_TIMEOUT") != "" {
+		timeout, err := strconv.Atoi(os.Getenv("SIGNALING_TIMEOUT"))
+		if err != nil || timeout < 0 {
+			fmt.Println("Invalid SIGNALING_TIMEOUT provided. It must be a number of seconds.")
+			os.Exit(1)
+		}
+		config.timeout = time.Duration(timeout) * time.Second
+	}
+
+	return config
+}
+
+// Source signaling using the webrtc-cdn websocket protocol
+type WebRTCCDNSignaling struct {
+	lock *sync.Mutex
+
+	url      url.URL
+	streamId string
+
+	// Provides the authentication token (optional)
+	auth AuthTokenProvider
+
+	liveness SignalingLivenessConfig
+
+	connection SignalingConnectionConfig
+
+	conn *websocket.Conn
+
+	// Number of requests of the stream, used to generate the request IDs
+	requestCount int
+
+	// ID of the current request
+	requestId string
+
+	// Closed when the current connection ends
+	connDone chan bool
+
+	closed bool
+
+	debug bool
+}
+
+// Creates new webrtc-cdn source signaling
+// url - Websocket URL of the webrtc-cdn server
+// streamId - ID of the stream to play
+// auth - Provider of the authentication token (optional)
+// liveness - Liveness configuration
+// connection - Websocket connection options
+func NewWebRTCCDNSignaling(url url.URL, streamId string, auth AuthTokenProvider, liveness SignalingLivenessConfig, connection SignalingConnectionConfig, debug bool) *WebRTCCDNSignaling {
+	return &WebRTCCDNSignaling{
+		lock:       &sync.Mutex{},
+		url:        url,
+		streamId:   streamId,
+		auth:       auth,
+		liveness:   liveness,
+		connection: connection,
+		conn:       nil,
+		requestId:  "",
+		connDone:   nil,
+		closed:     false,
+		debug:      debug,
+	}
+}
+
+func (s *WebRTCCDNSignaling) String() string {
+	return redactSecrets(s.url.String())
+}
+
+// Sends a signaling message
+// Must be called with the lock acquired
+func (s *WebRTCCDNSignaling) send(m signaling.TypedMessage) error {
+	if s.conn == nil {
+		return errors.New("not connected")
+	}
+
+	raw, err := signaling.Encode(m)
+
+	if err != nil {
+		fmt.Println("Error: Could not encode " + m.Method() + " message: " + err.Error())
+		return err
+	}
+
+	if s.debug {
+		fmt.Println("[SOURCE] >>>\n" + redactSecrets(raw))
+	}
+
+	s.conn.SetWriteDeadline(time.Now().Add(SIGNALING_WRITE_TIMEOUT))
+
+	return s.conn.WriteMessage(websocket.TextMessage, []byte(raw))
+}
+
+// Extends the read deadline, since the server is alive
+func (s *WebRTCCDNSignaling) extendReadDeadline(conn *websocket.Conn) {
+	if s.liveness.timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(s.liveness.timeout))
+	}
+}
+
+// Obtains a fresh authentication token for a new request
+func (s *WebRTCCDNSignaling) getAuthToken() (string, error) {
+	if s.auth == nil {
+		return "", nil
+	}
+
+	token, err := s.auth.GetToken()
+
+	if err != nil {
+		return "", err
+	}
+
+	registerSecret(token)
+
+	return token, nil
+}
+
+// Sends a new PLAY request, with a new request ID
+// Must be called with the lock acquired
+func (s *WebRTCCDNSignaling) sendPlay(authToken string) error {
+	s.requestCount++
+	s.requestId = SIGNALING_REQUEST_ID_PREFIX + fmt.Sprintf("%02d", s.requestCount)
+
+	return s.send(&signaling.Play{
+		RequestID: s.requestId,
+		StreamID:  s.streamId,
+		Auth:      authToken,
+	})
+}
+
+func (s *WebRTCCDNSignaling) Connect() error {
+	authToken, err := s.getAuthToken()
+
+	if err != nil {
+		return err
+	}
+
+	if s.debug {
+		fmt.Println("Connecting to " + s.String())
+	}
+
+	c, _, err := s.connection.dialer.Dial(s.url.String(), s.connection.headers)
+
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		c.Close()
+		return errors.New("signaling closed")
+	}
+
+	s.conn = c
+	s.connDone = make(chan bool)
+
+	s.extendReadDeadline(c)
+
+	c.SetPongHandler(func(string) error {
+		s.extendReadDeadline(c)
+		return nil
+	})
+
+	go s.sendHeartbeat(c, s.connDone)
+
+	// Send play message
+	return s.sendPlay(authToken)
+}
+
+func (s *WebRTCCDNSignaling) RequestOffer() error {
+	authToken, err := s.getAuthToken()
+
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.conn == nil {
+		return errors.New("not connected")
+	}
+
+	// Close the current request
+	err = s.send(&signaling.Close{
+		RequestID: s.requestId,
+		StreamID:  s.streamId,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return s.sendPlay(authToken)
+}
+
+// Checks if a received message belongs to an old request, so it must be ignored
+func (s *WebRTCCDNSignaling) isOldRequest(requestId string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return requestId != "" && requestId != s.requestId
+}
+
+// Sends heartbeat messages and pings periodically, until the connection ends
+func (s *WebRTCCDNSignaling) sendHeartbeat(conn *websocket.Conn, done chan bool) {
+	heartbeatTicker := time.NewTicker(s.liveness.heartbeatInterval)
+	defer heartbeatTicker.Stop()
+
+	var pingChan <-chan time.Time = nil
+
+	if s.liveness.pingInterval > 0 {
+		pingTicker := time.NewTicker(s.liveness.pingInterval)
+		defer pingTicker.Stop()
+		pingChan = pingTicker.C
+	}
+
+	for {
+		var sendErr error
+
+		select {
+		case <-done:
+			return
+		case <-heartbeatTicker.C:
+			// Send hearbeat message
+			s.lock.Lock()
+			sendErr = s.send(&signaling.Heartbeat{})
+			s.lock.Unlock()
+		case <-pingChan:
+			sendErr = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(SIGNALING_WRITE_TIMEOUT))
+		}
+
+		if sendErr != nil {
+			return
+		}
+	}
+}
+
+// Ends the current connection
+func (s *WebRTCCDNSignaling) disconnect(conn *websocket.Conn) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	conn.Close()
+
+	if s.conn == conn {
+		s.conn = nil
+		close(s.connDone)
+		s.connDone = nil
+	}
+}
+
+func (s *WebRTCCDNSignaling) Run(handlers SourceSignalingHandlers) *SourceClosedError {
+	s.lock.Lock()
+	conn := s.conn
+	s.lock.Unlock()
+
+	if conn == nil {
+		return &SourceClosedError{
+			Reason:         "not connected",
+			ConnectionLost: true,
+		}
+	}
+
+	defer s.disconnect(conn)
+
+	for {
+		_, message, err := conn.ReadMessage()
+
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				fmt.Println("Error: [SOURCE] The signaling server did not respond for " + fmt.Sprint(s.liveness.timeout))
+				return &SourceClosedError{
+					Reason:         "signaling timeout",
+					ConnectionLost: true,
+				}
+			}
+
+			return &SourceClosedError{
+				Reason:         "signaling connection closed",
+				ConnectionLost: true,
+			}
+		}
+
+		s.extendReadDeadline(conn)
+
+		if s.debug {
+			fmt.Println("[SOURCE] <<<\n" + redactSecrets(string(message)))
+		}
+
+		typedMsg, err := signaling.Decode(string(message))
+
+		if err != nil {
+			fmt.Println("Error: Invalid signaling message: " + err.Error())
+			continue
+		}
+
+		switch msg := typedMsg.(type) {
+		case *signaling.Error:
+			if s.isOldRequest(msg.RequestID) {
+				continue
+			}
+			fmt.Println("Error: " + msg.ErrorMessage)
+			return &SourceClosedError{
+				Reason:         "signaling error: " + msg.ErrorMessage,
+				ConnectionLost: false,
+			}
+		case *signaling.Offer:
+			if s.isOldRequest(msg.RequestID) {
+				continue
+			}
+			handlers.OnOffer(msg.Description)
+		case *signaling.Candidate:
+			if s.isOldRequest(msg.RequestID) {
+				continue
+			}
+			handlers.OnCandidate(msg.Candidate)
+		case *signaling.Close:
+			if s.isOldRequest(msg.RequestID) {
+				continue
+			}
+			fmt.Println("[SOURCE] Connection closed by remote host.")
+			return &SourceClosedError{
+				Reason:         "closed by remote host",
+				ConnectionLost: false,
+			}
+		case *signaling.Standby:
+			if s.isOldRequest(msg.RequestID) {
+				continue
+			}
+			handlers.OnStandby()
+		}
+	}
+}
+
+func (s *WebRTCCDNSignaling) SendAnswer(answer webrtc.SessionDescription) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.send(&signaling.Answer{
+		RequestID:   s.requestId,
+		StreamID:    s.streamId,
+		Description: answer,
+	})
+}
+
+func (s *WebRTCCDNSignaling) SendCandidate(candidate *webrtc.ICECandidateInit) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.send(&signaling.Candidate{
+		RequestID: s.requestId,
+		StreamID:  s.streamId,
+		Candidate: candidate,
+	})
+}
+
+func (s *WebRTCCDNSignaling) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.closed = true
+
+	if s.conn == nil {
+		return
+	}
+
+	s.send(&signaling.Close{
+		RequestID: s.requestId,
+		StreamID:  s.streamId,
+	})
+
+	s.conn.Close()
+}
@@ -0,0 +1,222 @@
+// Output supervisor
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// If an output runs for this time, the restart backoff is reset
+const OUTPUT_STABLE_TIME = 60 * time.Second
+
+// Policy to restart the outputs when they fail
+type OutputRestartPolicy struct {
+	// Max number of restarts for each output (0 = never restart, -1 = unlimited)
+	maxRestarts int
+
+	// Delay before the first restart
+	minBackoff time.Duration
+
+	// Max delay between restarts
+	maxBackoff time.Duration
+}
+
+// Loads the output restart policy from env variables
+func loadOutputRestartPolicy() OutputRestartPolicy {
+	policy := OutputRestartPolicy{
+		maxRestarts: 0,
+		minBackoff:  time.Second,
+		maxBackoff:  30 * time.Second,
+	}
+
+	if os.Getenv("OUTPUT_MAX_RESTARTS") != "" {
+		maxRestarts, err := strconv.Atoi(os.Getenv("OUTPUT_MAX_RESTARTS"))
+		if err != nil || maxRestarts < -1 {
+			fmt.Println("Invalid OUTPUT_MAX_RESTARTS provided. It must be a number of restarts, or -1 for unlimited.")
+			os.Exit(1)
+		}
+		policy.maxRestarts = maxRestarts
+	}
+
+	if os.Getenv("OUTPUT_RESTART_MIN_BACKOFF") != "" {
+		minBackoff, err := strconv.Atoi(os.Getenv("OUTPUT_RESTART_MIN_BACKOFF"))
+		if err != nil || minBackoff < 0 {
+			fmt.Println("Invalid OUTPUT_RESTART_MIN_BACKOFF provided. It must be a number of milliseconds.")
+			os.Exit(1)
+		}
+		policy.minBackoff = time.Duration(minBackoff) * time.Millisecond
+	}
+
+	if os.Getenv("OUTPUT_RESTART_MAX_BACKOFF") != "" {
+		maxBackoff, err := strconv.Atoi(os.Getenv("OUTPUT_RESTART_MAX_BACKOFF"))
+		if err != nil || maxBackoff < 0 {
+			fmt.Println("Invalid OUTPUT_RESTART_MAX_BACKOFF provided. It must be a number of milliseconds.")
+			os.Exit(1)
+		}
+		policy.maxBackoff = time.Duration(maxBackoff) * time.Millisecond
+	}
+
+	if policy.maxBackoff < policy.minBackoff {
+		policy.maxBackoff = policy.minBackoff
+	}
+
+	return policy
+}
+
+var (
+	outputs_restarts_lock = &sync.Mutex{}
+	outputs_restarts      = make(map[string]int)
+	outputs_reload        = make(map[string]bool)
+)
+
+// Restarts the running outputs, because the stream changed (for example, the video codec)
+// The restarts are not counted as failures
+func reloadOutputs(timeout time.Duration) {
+	outputs_lock.Lock()
+
+	running := make(map[string]*OutputProcess)
+
+	for name, p := range outputs_running {
+		running[name] = p
+	}
+
+	outputs_lock.Unlock()
+
+	outputs_restarts_lock.Lock()
+
+	for name := range running {
+		outputs_reload[name] = true
+	}
+
+	outputs_restarts_lock.Unlock()
+
+	for _, p := range running {
+		go p.Stop(timeout)
+	}
+}
+
+// Checks if an output was stopped to be reloaded, clearing the flag
+func checkOutputReload(name string) bool {
+	outputs_restarts_lock.Lock()
+	defer outputs_restarts_lock.Unlock()
+
+	reload := outputs_reload[name]
+	delete(outputs_reload, name)
+
+	return reload
+}
+
+// Gets the number of times an output was restarted
+func getOutputRestarts(name string) int {
+	outputs_restarts_lock.Lock()
+	defer outputs_restarts_lock.Unlock()
+
+	return outputs_restarts[name]
+}
+
+// Runs an output, restarting it with exponential backoff if it fails
+// The WebRTC connection and the UDP forwarding are not affected by the restarts
+// getInfo - Function to get the current stream information, called each time the output process is started
+// onStart - Function called each time the output process is started (restart is true if it is not the first time)
+func superviseOutput(output OutputConfig, options ProcessOptions, getInfo func() StreamInfo, onStart func(restart bool)) {
+	policy := options.outputRestart
+
+	restarts := 0
+	backoff := policy.minBackoff
+
+	// True if the process is started again after a reload
+	reloaded := false
+
+	for {
+		if isShuttingDown() {
+			// Do not start a new process after the outputs were stopped (for example, during the backoff)
+			onOutputEnded(output.Name, nil)
+			return
+		}
+
+		onStart(restarts > 0 || reloaded)
+
+		reloaded = false
+
+		emitEvent(EVENT_OUTPUT_STARTED, map[string]string{
+			"output":   output.Name,
+			"restarts": fmt.Sprint(restarts),
+		})
+
+		started := time.Now()
+
+		err := runOutput(output, options, getInfo())
+
+		shuttingDown := isShuttingDown()
+
+		if shuttingDown {
+			// Stopped by the shutdown
+			err = nil
+		}
+
+		if checkOutputReload(output.Name) && !shuttingDown {
+			// Stopped to be reloaded
+			emitEvent(EVENT_OUTPUT_EXITED, map[string]string{
+				"output":       output.Name,
+				"restarts":     fmt.Sprint(restarts),
+				"will_restart": "true",
+				"reason":       "reload",
+			})
+
+			fmt.Println("[" + output.Name + "] Reloading output")
+
+			reloaded = true
+
+			continue
+		}
+
+		willRestart := err != nil && !shuttingDown && (policy.maxRestarts < 0 || restarts < policy.maxRestarts)
+
+		exitDetails := map[string]string{
+			"output":       output.Name,
+			"restarts":     fmt.Sprint(restarts),
+			"will_restart": fmt.Sprint(willRestart),
+		}
+
+		if err != nil {
+			exitDetails["error"] = err.Error()
+		}
+
+		emitEvent(EVENT_OUTPUT_EXITED, exitDetails)
+
+		if !willRestart {
+			onOutputEnded(output.Name, err)
+			return
+		}
+
+		if time.Since(started) >= OUTPUT_STABLE_TIME {
+			backoff = policy.minBackoff
+		}
+
+		restarts++
+
+		outputs_restarts_lock.Lock()
+		outputs_restarts[output.Name] = restarts
+		outputs_restarts_lock.Unlock()
+
+		restartsStr := fmt.Sprint(restarts)
+
+		if policy.maxRestarts >= 0 {
+			restartsStr += "/" + fmt.Sprint(policy.maxRestarts)
+		}
+
+		fmt.Println("Error: [" + output.Name + "] Output failed: " + redactSecrets(err.Error()) + " | Restarting in " + fmt.Sprint(backoff) + " (restart " + restartsStr + ")")
+
+		time.Sleep(backoff)
+
+		backoff *= 2
+
+		if backoff > policy.maxBackoff {
+			backoff = policy.maxBackoff
+		}
+	}
+}
@@ -3,43 +3,71 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
-	"net/url"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/gorilla/websocket"
 	"github.com/pion/interceptor"
-	"github.com/pion/rtcp"
+	"github.com/pion/interceptor/pkg/stats"
 	"github.com/pion/webrtc/v3"
 )
 
 type ProcessOptions struct {
-	portAudio    int
-	portVideo    int
-	sdpFile      string
-	debug        bool
-	ffmpeg       string
-	authToken    string
-	forwardMode  string
-	forwardParam string
+	debug  bool
+	ffmpeg string
+
+	outputs []OutputConfig
+
+	jitterBufferLatency time.Duration
+
+	keyframePolicy KeyframePolicy
+
+	ffmpegProgress FFmpegProgressConfig
+
+	outputRestart OutputRestartPolicy
+
+	reconnect SourceReconnectPolicy
+
+	iceRestart ICERestartPolicy
+
+	webrtcConfig WebRTCConfig
+
+	webrtcSettings webrtc.SettingEngine
+
+	webrtcStats WebRTCStatsConfig
+
+	mediaInactivity MediaInactivityPolicy
 }
 
-func runProcess(source url.URL, sourceStreamId string, options ProcessOptions) {
+// Max number of remote candidates to keep while waiting for the offer
+const ICE_MAX_PENDING_CANDIDATES = 100
+
+func runProcess(source SourceSignaling, sourceStreamId string, options ProcessOptions) {
 	// Mutex
 	lock := sync.Mutex{}
 
+	registerOutputs(options.outputs)
+
+	emitEvent(EVENT_FORWARD_STARTED, map[string]string{
+		"outputs": fmt.Sprint(len(options.outputs)),
+	})
+
 	m := &webrtc.MediaEngine{}
 
 	// Setup the codecs you want to use.
-	// We'll use a VP8 and Opus but you can also define your own
+	// We'll use VP8 or H264 for video and Opus for audio
 	if err := m.RegisterCodec(webrtc.RTPCodecParameters{
 		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000, Channels: 0, SDPFmtpLine: "", RTCPFeedback: nil},
 	}, webrtc.RTPCodecTypeVideo); err != nil {
 		panic(err)
 	}
+	if err := m.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000, Channels: 0, SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f", RTCPFeedback: nil},
+		PayloadType:        102,
+	}, webrtc.RTPCodecTypeVideo); err != nil {
+		panic(err)
+	}
 	if err := m.RegisterCodec(webrtc.RTPCodecParameters{
 		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 0, SDPFmtpLine: "", RTCPFeedback: nil},
 	}, webrtc.RTPCodecTypeAudio); err != nil {
@@ -52,288 +80,887 @@ func runProcess(source url.URL, sourceStreamId string, options ProcessOptions) {
 	// for each PeerConnection.
 	i := &interceptor.Registry{}
 
+	// Stats of the RTP streams
+	// Registered first, so it also counts the RTCP feedback sent by the default interceptors
+	statsInterceptor, err := stats.NewInterceptor()
+	if err != nil {
+		panic(err)
+	}
+
+	// Set by the stats interceptor when a peer connection is created
+	// It is called from api.NewPeerConnection, with the lock acquired
+	var lastStatsGetter stats.Getter = nil
+
+	statsInterceptor.OnNewPeerConnection(func(id string, getter stats.Getter) {
+		lastStatsGetter = getter
+	})
+
+	i.Add(statsInterceptor)
+
 	// Use the default set of Interceptors
 	if err := webrtc.RegisterDefaultInterceptors(m, i); err != nil {
 		panic(err)
 	}
 
+	if options.keyframePolicy.useFIR {
+		m.RegisterFeedback(webrtc.RTCPFeedback{Type: "ccm", Parameter: "fir"}, webrtc.RTPCodecTypeVideo)
+	}
+
 	// Create the API object with the MediaEngine
-	api := webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(i))
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(i), webrtc.WithSettingEngine(options.webrtcSettings))
+
+	receivedOffer := false
+	receivedVideoTrack := false
+	receivedAudioTrack := false
+	tracksReady := false
+	keyframeReceived := false
+	outputStarted := false
+	outputsListening := false
+	videoCodec := ""
+	audioCodec := ""
+
+	var gate *ForwardGate = nil
+	var keyframeRequester *KeyframeRequester = nil
+
+	var peerConnection *webrtc.PeerConnection = nil
+
+	// Stats of the RTP streams of the current session
+	var statsGetter stats.Getter = nil
+
+	// True while connected to the signaling server
+	signalingConnected := false
+
+	// Current tracks, for the statistics
+	var videoTrack *webrtc.TrackRemote = nil
+	var audioTrack *webrtc.TrackRemote = nil
+
+	// Tracks expected by the current offer
+	expectVideo := false
+	expectAudio := false
+
+	// Closed to stop forwarding the current tracks, when they are replaced
+	var videoTrackStop chan bool = nil
+	var audioTrackStop chan bool = nil
+
+	// Status of the SDP files of the outputs
+	sdpCreated := false
+	sdpIsH264 := false
+
+	// Candidates received before the offer
+	pendingCandidates := make([]*webrtc.ICECandidateInit, 0)
 
-	// Connect to websocket
-	if options.debug {
-		fmt.Println("Connecting to " + source.String())
+	// Consecutive restarts of the WebRTC session
+	iceRestartAttempts := 0
+
+	// Consecutive restarts of the WebRTC session because of inactive tracks
+	inactivityRestarts := NewInactivityRestartBudget(options.iceRestart.maxAttempts)
+
+	// Close the source on shutdown
+	onShutdown(func() {
+		source.Close()
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		if peerConnection != nil {
+			peerConnection.Close()
+		}
+	})
+
+	// Reports the status of the forward, for the health checks
+	setForwardStatusProvider(func() ForwardStatus {
+		lock.Lock()
+		defer lock.Unlock()
+
+		status := ForwardStatus{
+			SignalingConnected: signalingConnected,
+			WebRTCState:        "",
+			Tracks:             make(map[string]time.Time),
+		}
+
+		if peerConnection == nil {
+			return status
+		}
+
+		status.WebRTCState = peerConnection.ConnectionState().String()
+
+		if expectVideo {
+			status.Tracks["video"] = getTrackLastPacket(statsGetter, videoTrack)
+		}
+
+		if expectAudio {
+			status.Tracks["audio"] = getTrackLastPacket(statsGetter, audioTrack)
+		}
+
+		return status
+	})
+
+	// Gets the current information of the stream, for the outputs
+	getStreamInfo := func() StreamInfo {
+		lock.Lock()
+		defer lock.Unlock()
+
+		return StreamInfo{
+			streamId:   sourceStreamId,
+			videoCodec: codecNameFromMimeType(videoCodec),
+			audioCodec: codecNameFromMimeType(audioCodec),
+		}
 	}
-	c, _, err := websocket.DefaultDialer.Dial(source.String(), nil)
-	if err != nil {
-		fmt.Println("Error: " + err.Error())
+
+	// Requests a keyframe, once the ports of the outputs are listening
+	requestKeyframeWhenListening := func(outputs []OutputConfig, reason string) {
+		ports := make([]int, 0, 2*len(outputs))
+
+		for _, output := range outputs {
+			if output.Mode != "TEST" {
+				ports = append(ports, output.VideoPort, output.AudioPort)
+			}
+		}
+
+		if !waitForwardPortsListening(ports, FORWARD_PORT_READY_TIMEOUT) {
+			fmt.Println("Warning: The outputs are not listening after " + fmt.Sprint(FORWARD_PORT_READY_TIMEOUT) + " | Starting the forward anyway")
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		outputsListening = true
+
+		if gate != nil {
+			gate.SetReady()
+		}
+
+		// The output needs a keyframe to start decoding
+		if keyframeRequester != nil {
+			keyframeRequester.Request(reason)
+		}
+	}
+
+	// Starts the outputs once all the tracks are ready
+	// The forward starts with a keyframe, once the outputs are listening
+	// Must be called with the lock acquired
+	startOutputIfReady := func() {
+		if outputStarted || !tracksReady {
+			return
+		}
+
+		outputStarted = true
+
+		fmt.Println("Tracks ready | Starting outputs")
+
+		// Publish
+		for _, output := range getProcessOutputs(options.outputs) {
+			go superviseOutput(output, options, getStreamInfo, func(restart bool) {
+				if restart {
+					go requestKeyframeWhenListening([]OutputConfig{output}, "output restart")
+				}
+			})
+		}
+
+		go requestKeyframeWhenListening(options.outputs, "output start")
 	}
-	defer c.Close()
 
-	go func() {
-		for {
-			time.Sleep(20 * time.Second)
+	// Creates the SDP files of the outputs, or updates them if the video codec changed
+	// If the outputs are running, they are reloaded to use the new SDP files
+	// Must be called with the lock acquired
+	updateSDPFiles := func() {
+		isH264 := strings.EqualFold(videoCodec, webrtc.MimeTypeH264)
+
+		if sdpCreated && sdpIsH264 == isH264 {
+			return // Up to date
+		}
+
+		codecChanged := sdpCreated
+
+		sdpCreated = true
+		sdpIsH264 = isH264
+
+		for _, output := range options.outputs {
+			sdpFile := createForwardSDPFile(output.SDPFile, output.VideoPort, output.AudioPort, videoCodec)
+			addTemporaryFile(sdpFile)
+			fmt.Println("[" + output.Name + "] Tracks received | Created SDP file: " + sdpFile)
+		}
+
+		if codecChanged && outputStarted {
+			fmt.Println("The video codec changed to " + codecNameFromMimeType(videoCodec) + " | Reloading outputs")
+			go reloadOutputs(shutdown_timeout)
+		}
+	}
+
+	// Adds a remote candidate to the peer connection
+	// nil indicates the end of the candidates
+	// Must be called with the lock acquired, after setting the remote description
+	addCandidate := func(candidate *webrtc.ICECandidateInit) {
+		var err error
+
+		if candidate != nil {
+			err = peerConnection.AddICECandidate(*candidate)
+		} else {
+			err = peerConnection.AddICECandidate(webrtc.ICECandidateInit{})
+		}
+
+		if err != nil {
+			fmt.Println("Error: " + err.Error())
+		}
+	}
+
+	// Applies an offer to the peer connection and sends the answer
+	// Must be called with the lock acquired
+	answerOffer := func(sd webrtc.SessionDescription) {
+		err := peerConnection.SetRemoteDescription(sd)
+
+		if err != nil {
+			fmt.Println("Error: " + err.Error())
+			return
+		}
 
-			// Send hearbeat message
-			heartbeatMessage := SignalingMessage{
-				method: "HEARTBEAT",
-				params: nil,
-				body:   "",
+		// Add the candidates received before the offer
+		for _, candidate := range pendingCandidates {
+			addCandidate(candidate)
+		}
+
+		pendingCandidates = pendingCandidates[:0]
+
+		// Generate answer
+		answer, err := peerConnection.CreateAnswer(nil)
+		if err != nil {
+			fmt.Println("Error: " + err.Error())
+			return
+		}
+
+		// Sets the LocalDescription, and starts our UDP listeners
+		err = peerConnection.SetLocalDescription(answer)
+		if err != nil {
+			fmt.Println("Error: " + err.Error())
+			return
+		}
+
+		// Send ANSWER to the client
+		err = source.SendAnswer(answer)
+		if err != nil {
+			fmt.Println("Error: " + err.Error())
+		}
+	}
+
+	// Called when the forward of a track ends
+	// stop - Channel of the forward, to check if the track is still the current one
+	onTrackEnded := func(kind webrtc.RTPCodecType, stop chan bool) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if kind == webrtc.RTPCodecTypeVideo && videoTrackStop == stop {
+			fmt.Println("[VIDEO] Track ended")
+
+			close(videoTrackStop)
+			videoTrackStop = nil
+			videoTrack = nil
+			receivedVideoTrack = false
+
+			if keyframeRequester != nil {
+				keyframeRequester.Close()
+				keyframeRequester = nil
 			}
+		} else if kind == webrtc.RTPCodecTypeAudio && audioTrackStop == stop {
+			fmt.Println("[AUDIO] Track ended")
 
-			lock.Lock()
-			sendErr := c.WriteMessage(websocket.TextMessage, []byte(heartbeatMessage.serialize()))
-			lock.Unlock()
+			close(audioTrackStop)
+			audioTrackStop = nil
+			audioTrack = nil
+			receivedAudioTrack = false
+		}
+	}
+
+	// Declared below
+	var restartSession func(reason string)
 
-			if options.debug {
-				fmt.Println("[SOURCE] >>>\n" + string(heartbeatMessage.serialize()))
+	// Called when no packets are received for a track
+	// stop - Channel of the forward, to check if the track is still the current one
+	onTrackInactive := func(kind webrtc.RTPCodecType, stop chan bool, idle time.Duration) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if (kind == webrtc.RTPCodecTypeVideo && videoTrackStop != stop) || (kind == webrtc.RTPCodecTypeAudio && audioTrackStop != stop) {
+			return // Old track
+		}
+
+		action := options.mediaInactivity.action
+
+		fmt.Println("[" + strings.ToUpper(kind.String()) + "] No packets received for " + fmt.Sprint(idle.Round(time.Second)) + " | Action: " + action)
+
+		emitEvent(EVENT_MEDIA_INACTIVE, map[string]string{
+			"track":  kind.String(),
+			"idle":   fmt.Sprint(int(idle.Seconds())),
+			"action": action,
+		})
+
+		switch action {
+		case MEDIA_INACTIVITY_ACTION_KEYFRAME:
+			// The publisher may recover when asked for a keyframe
+			if kind == webrtc.RTPCodecTypeVideo && keyframeRequester != nil {
+				keyframeRequester.Request("media inactive")
+			}
+		case MEDIA_INACTIVITY_ACTION_RECONNECT:
+			if inactivityRestarts.Use() {
+				restartSession("No " + kind.String() + " packets received")
+				return
 			}
 
-			if sendErr != nil {
+			// No attempts left, end the forward
+			fmt.Println("[SOURCE] No attempts left to request a new offer")
+
+			if isShuttingDown() {
 				return
 			}
-		}
-	}()
 
-	// Send play message
-	pubMsg := SignalingMessage{
-		method: "PLAY",
-		params: make(map[string]string),
-		body:   "",
+			emitEvent(EVENT_DISCONNECTED, map[string]string{
+				"reason": "no " + kind.String() + " packets received",
+			})
+
+			go shutdown(1)
+		case MEDIA_INACTIVITY_ACTION_END:
+			if isShuttingDown() {
+				return
+			}
+
+			emitEvent(EVENT_DISCONNECTED, map[string]string{
+				"reason": "no " + kind.String() + " packets received",
+			})
+
+			go shutdown(1)
+		}
 	}
-	pubMsg.params["Request-ID"] = "play01"
-	pubMsg.params["Stream-ID"] = sourceStreamId
-	if options.authToken != "" {
-		pubMsg.params["Auth"] = options.authToken
+
+	// Called when the first packet of a track is received
+	onTrackActive := func(kind webrtc.RTPCodecType, stop chan bool) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if (kind == webrtc.RTPCodecTypeVideo && videoTrackStop != stop) || (kind == webrtc.RTPCodecTypeAudio && audioTrackStop != stop) {
+			return // Old track
+		}
+
+		// The media flows again after the restarts
+		inactivityRestarts.Reset()
 	}
-	c.WriteMessage(websocket.TextMessage, []byte(pubMsg.serialize()))
 
-	if options.debug {
-		fmt.Println("[SOURCE] >>>\n" + string(pubMsg.serialize()))
+	// Called when packets are received again for an inactive track
+	onTrackResumed := func(kind webrtc.RTPCodecType, stop chan bool) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if (kind == webrtc.RTPCodecTypeVideo && videoTrackStop != stop) || (kind == webrtc.RTPCodecTypeAudio && audioTrackStop != stop) {
+			return // Old track
+		}
+
+		inactivityRestarts.Reset()
+
+		fmt.Println("[" + strings.ToUpper(kind.String()) + "] Packets received again")
+
+		emitEvent(EVENT_MEDIA_RESUMED, map[string]string{
+			"track": kind.String(),
+		})
 	}
 
-	receivedOffer := false
-	receivedVideoTrack := false
-	receivedAudioTrack := false
-	closed := false
+	// Handles the offer received from the source
+	onOffer := func(sd webrtc.SessionDescription) {
+		lock.Lock()
+		defer lock.Unlock()
 
-	var peerConnection *webrtc.PeerConnection = nil
+		hasVideo, hasAudio := getOfferedTracks(sd)
 
-	// Read websocket messages
-	for {
-		if closed {
+		if err := checkDTLSFingerprints(sd.SDP, options.webrtcConfig.remoteFingerprints); err != nil {
+			if isShuttingDown() {
+				return
+			}
+
+			fmt.Println("Error: [SOURCE] Rejected offer: " + err.Error())
+			emitEvent(EVENT_DISCONNECTED, map[string]string{
+				"reason": "rejected offer: " + err.Error(),
+			})
+			go shutdown(1)
 			return
 		}
-		func() {
-			_, message, err := c.ReadMessage()
-			if err != nil {
-				closed = true
-				killProcess()
-				return // Closed
+
+		if receivedOffer {
+			if peerConnection == nil {
+				return // The session could not be created
+			}
+
+			// Renegotiation
+			// The tracks may be added, removed or replaced
+			fmt.Println("[SOURCE] Received a new offer | Renegotiating")
+
+			emitEvent(EVENT_OFFER_RECEIVED, map[string]string{
+				"renegotiation": "true",
+			})
+
+			if !hasAudio && !hasVideo {
+				fmt.Println("Error: The incoming WebRTC offer did not have any track.")
+				return
 			}
 
-			if options.debug {
-				fmt.Println("[SOURCE] <<<\n" + string(message))
+			expectVideo = hasVideo
+			expectAudio = hasAudio
+
+			answerOffer(sd)
+
+			return
+		}
+
+		receivedOffer = true
+
+		emitEvent(EVENT_OFFER_RECEIVED, nil)
+
+		if !hasAudio && !hasVideo {
+			fmt.Println("Error: The incoming WebRTC offer did not have any track.")
+			return
+		}
+
+		expectVideo = hasVideo
+		expectAudio = hasAudio
+
+		// Hold the forward until a video keyframe is received
+		keyframeReceived = !hasVideo
+		gate = NewForwardGate(hasVideo, func() {
+			lock.Lock()
+			defer lock.Unlock()
+
+			keyframeReceived = true
+
+			fmt.Println("Keyframe received | Forwarding")
+		})
+
+		if outputsListening {
+			gate.SetReady()
+		}
+
+		// Create peer connection
+		var err error
+		peerConnectionConfig := options.webrtcConfig.PeerConnectionConfig()
+		peerConnection, err = api.NewPeerConnection(peerConnectionConfig)
+		if err != nil {
+			fmt.Println("Error: " + err.Error())
+			return
+		}
+
+		pc := peerConnection
+		sessionGate := gate
+
+		statsGetter = lastStatsGetter
+
+		// Statistics
+		go monitorWebRTCStats(pc, lastStatsGetter, func() []*webrtc.TrackRemote {
+			lock.Lock()
+			defer lock.Unlock()
+
+			tracks := make([]*webrtc.TrackRemote, 0, 2)
+
+			if peerConnection != pc {
+				return tracks // Old session
+			}
+
+			if videoTrack != nil {
+				tracks = append(tracks, videoTrack)
+			}
+
+			if audioTrack != nil {
+				tracks = append(tracks, audioTrack)
 			}
 
-			msg := parseSignalingMessage(string(message))
+			return tracks
+		}, options.webrtcStats)
 
+		// Track listener
+		// If a track of the same kind is already being forwarded (renegotiation), the new one replaces it
+		peerConnection.OnTrack(func(remoteTrack *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
 			lock.Lock()
 			defer lock.Unlock()
 
-			if msg.method == "ERROR" {
-				fmt.Println("Error: " + msg.params["error-message"])
-				killProcess()
-			} else if msg.method == "OFFER" {
-				if !receivedOffer {
-					receivedOffer = true
+			if peerConnection != pc {
+				return // Old session
+			}
 
-					// Parse remote description
-					sd := webrtc.SessionDescription{}
+			stop := make(chan bool)
 
-					err := json.Unmarshal([]byte(msg.body), &sd)
+			if remoteTrack.Kind() == webrtc.RTPCodecTypeVideo {
+				if videoTrackStop != nil {
+					fmt.Println("[VIDEO] Track replaced")
+					close(videoTrackStop)
+				}
 
-					if err != nil {
-						fmt.Println("Error: " + err.Error())
-						return
-					}
+				videoTrackStop = stop
+				videoTrack = remoteTrack
+				receivedVideoTrack = true
+				videoCodec = remoteTrack.Codec().MimeType
+
+				if keyframeRequester != nil {
+					keyframeRequester.Close()
+				}
 
-					hasVideo := strings.Contains(sd.SDP, "m=video")
-					hasAudio := strings.Contains(sd.SDP, "m=audio")
+				keyframeRequester = NewKeyframeRequester(peerConnection, uint32(remoteTrack.SSRC()), options.keyframePolicy, options.debug)
 
-					if !hasAudio && !hasVideo {
-						fmt.Println("Error: The incoming WebRTC offer did not have any track.")
-						return
-					}
+				// Request a keyframe right away, since the forward waits for it
+				keyframeRequester.Request("track received")
 
-					// Create peer connection
-					peerConnectionConfig := loadWebRTCConfig() // Load config
-					peerConnection, err = api.NewPeerConnection(peerConnectionConfig)
-					if err != nil {
-						fmt.Println("Error: " + err.Error())
+				go keyframeRequester.RunPeriodic()
+
+				// Forward track
+				go func(kr *KeyframeRequester) {
+					forwardTrack(remoteTrack, getOutputsVideoPorts(options.outputs), sessionGate, kr, stop, options.jitterBufferLatency, options.debug)
+					onTrackEnded(webrtc.RTPCodecTypeVideo, stop)
+				}(keyframeRequester)
+			} else if remoteTrack.Kind() == webrtc.RTPCodecTypeAudio {
+				if audioTrackStop != nil {
+					fmt.Println("[AUDIO] Track replaced")
+					close(audioTrackStop)
+				}
+
+				audioTrackStop = stop
+				audioTrack = remoteTrack
+				receivedAudioTrack = true
+				audioCodec = remoteTrack.Codec().MimeType
+
+				// Forward track
+				go func() {
+					forwardTrack(remoteTrack, getOutputsAudioPorts(options.outputs), sessionGate, nil, stop, options.jitterBufferLatency, options.debug)
+					onTrackEnded(webrtc.RTPCodecTypeAudio, stop)
+				}()
+			} else {
+				return // Unknown track type
+			}
+
+			// Detect the publisher stopping sending packets
+			kind := remoteTrack.Kind()
+
+			go monitorTrackActivity(remoteTrack, statsGetter, options.mediaInactivity, stop, func() {
+				onTrackActive(kind, stop)
+			}, func(idle time.Duration) {
+				onTrackInactive(kind, stop, idle)
+			}, func() {
+				onTrackResumed(kind, stop)
+			})
+
+			if (!expectVideo || receivedVideoTrack) && (!expectAudio || receivedAudioTrack) {
+				// Received all tracks
+				updateSDPFiles()
+
+				if tracksReady {
+					return
+				}
+
+				tracksReady = true
+
+				emitEvent(EVENT_TRACKS_READY, map[string]string{
+					"video_codec": codecNameFromMimeType(videoCodec),
+					"audio_codec": codecNameFromMimeType(audioCodec),
+				})
+
+				if !keyframeReceived {
+					fmt.Println("Waiting for a keyframe to start the forward")
+				}
+
+				startOutputIfReady()
+			}
+		})
+
+		// ICE Candidate handler
+		peerConnection.OnICECandidate(func(i *webrtc.ICECandidate) {
+			// Wait for the answer to be sent
+			lock.Lock()
+			defer lock.Unlock()
+
+			if peerConnection != pc {
+				return // Old session
+			}
+
+			var candidate *webrtc.ICECandidateInit = nil
+
+			if i != nil {
+				c := i.ToJSON()
+				candidate = &c
+			}
+
+			source.SendCandidate(candidate)
+		})
+
+		peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+			lock.Lock()
+			current := peerConnection == pc
+			lock.Unlock()
+
+			if !current {
+				return // Old session
+			}
+
+			if state == webrtc.PeerConnectionStateDisconnected && options.iceRestart.maxAttempts > 0 {
+				fmt.Println("[SOURCE] WebRTC: Connection interrupted")
+
+				// Restart if the connection is not recovered in time
+				go func() {
+					time.Sleep(options.iceRestart.delay)
+
+					lock.Lock()
+					defer lock.Unlock()
+
+					if peerConnection != pc || isShuttingDown() {
 						return
 					}
 
-					// Track listener
-					peerConnection.OnTrack(func(remoteTrack *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
-						lock.Lock()
-						defer lock.Unlock()
-
-						// Send a PLI on an interval so that the publisher is pushing a keyframe every rtcpPLIInterval
-						go func() {
-							ticker := time.NewTicker(time.Second * 2)
-							for range ticker.C {
-								if rtcpErr := peerConnection.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(remoteTrack.SSRC())}}); rtcpErr != nil {
-									fmt.Println(rtcpErr)
-								}
-							}
-						}()
-
-						if remoteTrack.Kind() == webrtc.RTPCodecTypeVideo {
-							if receivedVideoTrack {
-								return // Already received the track
-							}
-
-							receivedVideoTrack = true
-
-							// Forward track
-							go forwardTrack(remoteTrack, options.portVideo)
-						} else if remoteTrack.Kind() == webrtc.RTPCodecTypeAudio {
-							if receivedAudioTrack {
-								return // Already received the track
-							}
-
-							receivedAudioTrack = true
-
-							// Forward track
-							go forwardTrack(remoteTrack, options.portAudio)
-						} else {
-							return // Unknown track type
-						}
-
-						if (!hasVideo || receivedVideoTrack) && (!hasAudio || receivedAudioTrack) {
-							// Received all tracks
-							// Create SDP file
-							sdpFile := createForwardSDPFile(options.sdpFile, options.portVideo, options.portAudio)
-							fmt.Println("Tracks received | Created SDP file: " + sdpFile)
-
-							// Publish
-							if options.forwardMode == "CUSTOM" {
-								forwardCustom(options.forwardParam, options.debug)
-							} else if options.forwardMode == "RTMP" {
-								forwardToRTMP(options.ffmpeg, options.sdpFile, options.forwardParam, options.debug)
-							}
-						}
-					})
-
-					// ICE Candidate handler
-					peerConnection.OnICECandidate(func(i *webrtc.ICECandidate) {
-						lock.Lock()
-						defer lock.Unlock()
-
-						candidateMsg := SignalingMessage{
-							method: "CANDIDATE",
-							params: make(map[string]string),
-							body:   "",
-						}
-						candidateMsg.params["Request-ID"] = "play01"
-						candidateMsg.params["Stream-ID"] = sourceStreamId
-						if i != nil {
-							b, e := json.Marshal(i.ToJSON())
-							if e != nil {
-								fmt.Println("Error: " + e.Error())
-							} else {
-								candidateMsg.body = string(b)
-							}
-						}
-
-						c.WriteMessage(websocket.TextMessage, []byte(candidateMsg.serialize()))
-						if options.debug {
-							fmt.Println("[SOURCE] >>>\n" + string(candidateMsg.serialize()))
-						}
-					})
-
-					peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
-						lock.Lock()
-						defer lock.Unlock()
-
-						if state == webrtc.PeerConnectionStateClosed || state == webrtc.PeerConnectionStateFailed {
-							fmt.Println("[SOURCE] WebRTC: Disconnected")
-							killProcess()
-						} else if state == webrtc.PeerConnectionStateConnected {
-							fmt.Println("[SOURCE] WebRTC: Connected")
-						}
-					})
-
-					// Set remote rescription
-
-					err = peerConnection.SetRemoteDescription(sd)
-
-					if err != nil {
-						fmt.Println("Error: " + err.Error())
+					if pc.ConnectionState() == webrtc.PeerConnectionStateDisconnected && iceRestartAttempts < options.iceRestart.maxAttempts {
+						restartSession("WebRTC connection disconnected")
 					}
+				}()
+			} else if state == webrtc.PeerConnectionStateClosed || state == webrtc.PeerConnectionStateFailed {
+				if isShuttingDown() {
+					return
+				}
 
-					// Generate answer
-					answer, err := peerConnection.CreateAnswer(nil)
-					if err != nil {
-						fmt.Println("Error: " + err.Error())
+				if state == webrtc.PeerConnectionStateFailed {
+					lock.Lock()
+					restarted := peerConnection == pc && iceRestartAttempts < options.iceRestart.maxAttempts
+					if restarted {
+						restartSession("WebRTC connection failed")
 					}
+					lock.Unlock()
 
-					// Sets the LocalDescription, and starts our UDP listeners
-					err = peerConnection.SetLocalDescription(answer)
-					if err != nil {
-						fmt.Println("Error: " + err.Error())
+					if restarted {
+						return
 					}
+				}
 
-					// Send ANSWER to the client
+				fmt.Println("[SOURCE] WebRTC: Disconnected")
+				emitEvent(EVENT_DISCONNECTED, map[string]string{
+					"reason": "WebRTC connection " + state.String(),
+				})
+				go shutdown(0)
+			} else if state == webrtc.PeerConnectionStateConnected {
+				fmt.Println("[SOURCE] WebRTC: Connected")
+
+				lock.Lock()
+				iceRestartAttempts = 0
+				lock.Unlock()
+			}
+		})
 
-					answerJSON, e := json.Marshal(answer)
+		answerOffer(sd)
+	}
 
-					if e != nil {
-						fmt.Println("Error: " + err.Error())
-					}
+	// Closes the current WebRTC session, in order to start a new one
+	// The outputs keep running, and receive the tracks of the new session
+	// Must be called with the lock acquired
+	resetSession := func() {
+		if peerConnection != nil {
+			peerConnection.Close()
+			peerConnection = nil
+		}
 
-					answerMsg := SignalingMessage{
-						method: "ANSWER",
-						params: make(map[string]string),
-						body:   string(answerJSON),
-					}
-					answerMsg.params["Request-ID"] = "play01"
-					answerMsg.params["Stream-ID"] = sourceStreamId
+		if keyframeRequester != nil {
+			keyframeRequester.Close()
+			keyframeRequester = nil
+		}
 
-					c.WriteMessage(websocket.TextMessage, []byte(answerMsg.serialize()))
+		receivedOffer = false
+		receivedVideoTrack = false
+		receivedAudioTrack = false
 
-					if options.debug {
-						fmt.Println(">>>\n" + string(answerMsg.serialize()))
-					}
-				}
-			} else if msg.method == "CANDIDATE" {
-				if receivedOffer && msg.body != "" {
-					candidate := webrtc.ICECandidateInit{}
+		if videoTrackStop != nil {
+			close(videoTrackStop)
+			videoTrackStop = nil
+		}
 
-					err := json.Unmarshal([]byte(msg.body), &candidate)
+		if audioTrackStop != nil {
+			close(audioTrackStop)
+			audioTrackStop = nil
+		}
 
-					if err != nil {
-						fmt.Println("Error: " + err.Error())
-					}
+		videoTrack = nil
+		audioTrack = nil
+		statsGetter = nil
 
-					err = peerConnection.AddICECandidate(candidate)
+		tracksReady = false
+		keyframeReceived = false
+		gate = nil
+		pendingCandidates = pendingCandidates[:0]
+	}
 
-					if err != nil {
-						fmt.Println("Error: " + err.Error())
-					}
-				}
-			} else if msg.method == "CLOSE" {
-				fmt.Println("[SOURCE] Connection closed by remote host.")
-				killProcess()
-			} else if msg.method == "STANDBY" {
-				if receivedOffer {
-					fmt.Println("[SOURCE] WebRTC connection closed.")
-					killProcess()
-				} else {
-					fmt.Println("[SOURCE] STANDBY. Waiting for the source to start publishing.")
-				}
+	// Restarts the WebRTC session, requesting a new offer to the source
+	// Since the forwarder is the answering side, the source must send the new offer
+	// Must be called with the lock acquired
+	restartSession = func(reason string) {
+		iceRestartAttempts++
+
+		fmt.Println("[SOURCE] " + reason + " | Requesting a new offer (attempt " + fmt.Sprint(iceRestartAttempts) + ")")
+
+		emitEvent(EVENT_ICE_RESTARTING, map[string]string{
+			"reason":  reason,
+			"attempt": fmt.Sprint(iceRestartAttempts),
+		})
+
+		resetSession()
+
+		go func() {
+			err := source.RequestOffer()
+
+			if err != nil {
+				fmt.Println("Error: Could not request a new offer: " + redactSecrets(err.Error()))
 			}
 		}()
 	}
+
+	handlers := SourceSignalingHandlers{
+		OnOffer: onOffer,
+		OnCandidate: func(candidate *webrtc.ICECandidateInit) {
+			lock.Lock()
+			defer lock.Unlock()
+
+			if peerConnection == nil || peerConnection.RemoteDescription() == nil {
+				// Wait for the offer
+				if len(pendingCandidates) < ICE_MAX_PENDING_CANDIDATES {
+					pendingCandidates = append(pendingCandidates, candidate)
+				}
+				return
+			}
+
+			addCandidate(candidate)
+		},
+		OnStandby: func() {
+			lock.Lock()
+			defer lock.Unlock()
+
+			if receivedOffer {
+				fmt.Println("[SOURCE] WebRTC connection closed.")
+				emitEvent(EVENT_DISCONNECTED, map[string]string{
+					"reason": "source stopped publishing",
+				})
+				go shutdown(0)
+			} else {
+				fmt.Println("[SOURCE] STANDBY. Waiting for the source to start publishing.")
+				emitEvent(EVENT_STANDBY, nil)
+			}
+		},
+	}
+
+	reconnectAttempts := 0
+
+	for {
+		// Connect to the source
+		var closeErr *SourceClosedError = nil
+
+		err := source.Connect()
+
+		if err != nil {
+			fmt.Println("Error: " + redactSecrets(err.Error()))
+
+			closeErr = &SourceClosedError{
+				Reason:         "could not connect: " + redactSecrets(err.Error()),
+				ConnectionLost: true,
+			}
+		} else {
+			reconnectAttempts = 0
+
+			lock.Lock()
+			signalingConnected = true
+			lock.Unlock()
+
+			emitEvent(EVENT_SIGNALING_CONNECTED, map[string]string{
+				"source": source.String(),
+			})
+
+			// Receive messages from the source
+			closeErr = source.Run(handlers)
+
+			lock.Lock()
+			signalingConnected = false
+			lock.Unlock()
+		}
+
+		if isShuttingDown() {
+			return
+		}
+
+		policy := options.reconnect
+
+		if closeErr.ConnectionLost && (policy.maxAttempts < 0 || reconnectAttempts < policy.maxAttempts) {
+			reconnectAttempts++
+
+			fmt.Println("[SOURCE] " + closeErr.Reason + " | Reconnecting in " + fmt.Sprint(policy.delay) + " (attempt " + fmt.Sprint(reconnectAttempts) + ")")
+
+			emitEvent(EVENT_RECONNECTING, map[string]string{
+				"reason":  closeErr.Reason,
+				"attempt": fmt.Sprint(reconnectAttempts),
+			})
+
+			lock.Lock()
+			resetSession()
+			iceRestartAttempts = 0
+			lock.Unlock()
+
+			time.Sleep(policy.delay)
+
+			continue
+		}
+
+		emitEvent(EVENT_DISCONNECTED, map[string]string{
+			"reason": closeErr.Reason,
+		})
+
+		if closeErr.ConnectionLost {
+			go shutdown(1)
+		} else {
+			go shutdown(0)
+		}
+
+		return
+	}
+}
+
+// Checks which kinds of tracks the source sends in an offer
+// Media sections rejected (port 0) or not sending (inactive or recvonly) are ignored,
+// so a track removed in a renegotiation is no longer expected
+func getOfferedTracks(sd webrtc.SessionDescription) (hasVideo bool, hasAudio bool) {
+	parsed, err := sd.Unmarshal()
+
+	if err != nil {
+		return false, false
+	}
+
+	// Direction for the media sections without one
+	sessionSending := true
+
+	if _, ok := parsed.Attribute("inactive"); ok {
+		sessionSending = false
+	} else if _, ok := parsed.Attribute("recvonly"); ok {
+		sessionSending = false
+	}
+
+	for _, media := range parsed.MediaDescriptions {
+		if media.MediaName.Port.Value == 0 {
+			continue
+		}
+
+		sending := sessionSending
+
+		if _, ok := media.Attribute("inactive"); ok {
+			sending = false
+		} else if _, ok := media.Attribute("recvonly"); ok {
+			sending = false
+		} else if _, ok := media.Attribute("sendonly"); ok {
+			sending = true
+		} else if _, ok := media.Attribute("sendrecv"); ok {
+			sending = true
+		}
+
+		if !sending {
+			continue
+		}
+
+		switch media.MediaName.Media {
+		case "video":
+			hasVideo = true
+		case "audio":
+			hasAudio = true
+		}
+	}
+
+	return hasVideo, hasAudio
 }
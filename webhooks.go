@@ -0,0 +1,205 @@
+// Webhook notifications
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Max number of events waiting to be sent
+const WEBHOOKS_QUEUE_SIZE = 256
+
+// Configuration of the webhook notifications
+type WebhookConfig struct {
+	// URL to send the events to
+	url string
+
+	// Secret to sign the events
+	secret string
+
+	// Events to send (nil = all)
+	events map[string]bool
+
+	// Max number of retries for each event
+	maxRetries int
+
+	// Delay before the first retry
+	retryDelay time.Duration
+
+	// Timeout for each request
+	timeout time.Duration
+
+	debug bool
+}
+
+var (
+	webhooks_config = WebhookConfig{}
+	webhooks_queue  = make(chan LifecycleEvent, WEBHOOKS_QUEUE_SIZE)
+)
+
+// Loads the webhooks configuration from env variables,
+// and starts sending the events
+func loadWebhookConfig(debug bool) {
+	webhooks_config = WebhookConfig{
+		url:        os.Getenv("WEBHOOK_URL"),
+		secret:     getSecretEnv("WEBHOOK_SECRET"),
+		events:     nil,
+		maxRetries: 3,
+		retryDelay: time.Second,
+		timeout:    10 * time.Second,
+		debug:      debug,
+	}
+
+	if webhooks_config.url == "" {
+		return
+	}
+
+	registerURLSecrets(webhooks_config.url)
+
+	u, err := url.Parse(webhooks_config.url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		fmt.Println("Invalid WEBHOOK_URL provided. It must be a valid HTTP(S) URL.")
+		os.Exit(1)
+	}
+
+	if os.Getenv("WEBHOOK_EVENTS") != "" {
+		webhooks_config.events = make(map[string]bool)
+
+		for _, event := range splitCommaList(os.Getenv("WEBHOOK_EVENTS")) {
+			event = strings.ToLower(event)
+
+			if !isLifecycleEvent(event) {
+				fmt.Println("Invalid WEBHOOK_EVENTS provided. Unknown event: " + event + ". Valid events: " + strings.Join(LIFECYCLE_EVENTS, ", "))
+				os.Exit(1)
+			}
+
+			webhooks_config.events[event] = true
+		}
+	}
+
+	if os.Getenv("WEBHOOK_RETRIES") != "" {
+		maxRetries, err := strconv.Atoi(os.Getenv("WEBHOOK_RETRIES"))
+		if err != nil || maxRetries < 0 {
+			fmt.Println("Invalid WEBHOOK_RETRIES provided. It must be a number of retries.")
+			os.Exit(1)
+		}
+		webhooks_config.maxRetries = maxRetries
+	}
+
+	if os.Getenv("WEBHOOK_TIMEOUT") != "" {
+		timeout, err := strconv.Atoi(os.Getenv("WEBHOOK_TIMEOUT"))
+		if err != nil || timeout <= 0 {
+			fmt.Println("Invalid WEBHOOK_TIMEOUT provided. It must be a number of seconds.")
+			os.Exit(1)
+		}
+		webhooks_config.timeout = time.Duration(timeout) * time.Second
+	}
+
+	go runWebhooksSender()
+}
+
+// Checks if an event must be sent to the webhook
+func hasWebhook(event string) bool {
+	if webhooks_config.url == "" {
+		return false
+	}
+
+	return webhooks_config.events == nil || webhooks_config.events[event]
+}
+
+// Computes the signature of a webhook body
+// HMAC-SHA256 using the shared secret, encoded in hex
+func signWebhookBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sends the queued events, one at a time, in order
+func runWebhooksSender() {
+	client := &http.Client{
+		Timeout: webhooks_config.timeout,
+	}
+
+	for e := range webhooks_queue {
+		sendWebhook(client, e)
+		events_pending.Done()
+	}
+}
+
+// Sends an event to the webhook, retrying if it fails
+func sendWebhook(client *http.Client, e LifecycleEvent) {
+	body, err := json.Marshal(e)
+
+	if err != nil {
+		fmt.Println("Error: [WEBHOOK] " + e.Event + ": " + redactSecrets(err.Error()))
+		return
+	}
+
+	delay := webhooks_config.retryDelay
+
+	for attempt := 0; ; attempt++ {
+		err = postWebhook(client, body)
+
+		if err == nil {
+			if webhooks_config.debug {
+				fmt.Println("[WEBHOOK] Sent event: " + e.Event)
+			}
+			return
+		}
+
+		if attempt >= webhooks_config.maxRetries {
+			fmt.Println("Error: [WEBHOOK] " + e.Event + ": " + redactSecrets(err.Error()))
+			return
+		}
+
+		if webhooks_config.debug {
+			fmt.Println("[WEBHOOK] " + e.Event + ": " + redactSecrets(err.Error()) + " | Retrying in " + fmt.Sprint(delay))
+		}
+
+		time.Sleep(delay)
+
+		delay *= 2
+	}
+}
+
+// Posts the body of an event to the webhook
+func postWebhook(client *http.Client, body []byte) error {
+	req, err := http.NewRequest("POST", webhooks_config.url, bytes.NewReader(body))
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if webhooks_config.secret != "" {
+		req.Header.Set("X-Signature", "sha256="+signWebhookBody(webhooks_config.secret, body))
+	}
+
+	res, err := client.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return errors.New("unexpected status code: " + fmt.Sprint(res.StatusCode))
+	}
+
+	return nil
+}
@@ -3,38 +3,233 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/pion/webrtc/v3"
 )
 
+// Default STUN server, used if no ICE servers are configured
+const DEFAULT_STUN_SERVER = "stun:stun.l.google.com:19302"
+
+// ICE server configuration
+type ICEServerConfig struct {
+	// URLs of the server (stun:, stuns:, turn: or turns:)
+	URLs []string `json:"urls"`
+
+	// Username for TURN servers
+	Username string `json:"username"`
+
+	// Credential for TURN servers
+	Credential string `json:"credential"`
+
+	// Shared secret to generate time-limited TURN credentials (TURN REST API)
+	// If set, the credential is computed from it
+	Secret string `json:"secret"`
+}
+
+// WebRTC configuration
+type WebRTCConfig struct {
+	// ICE servers
+	iceServers []ICEServerConfig
+
+	// ICE transport policy
+	iceTransportPolicy webrtc.ICETransportPolicy
+
+	// Time to live of the TURN REST credentials
+	turnCredentialsTTL time.Duration
+
+	// DTLS certificate (nil = random for each connection)
+	certificate *webrtc.Certificate
+
+	// Expected fingerprints of the remote DTLS certificate (empty = any)
+	remoteFingerprints []DTLSFingerprintPin
+}
+
+// Checks an ICE server configuration
+func validateICEServerConfig(server ICEServerConfig) error {
+	if len(server.URLs) == 0 {
+		return errors.New("ICE server without URLs")
+	}
+
+	for _, u := range server.URLs {
+		if strings.HasPrefix(u, "turn:") || strings.HasPrefix(u, "turns:") {
+			if server.Secret == "" && (server.Username == "" || server.Credential == "") {
+				return errors.New("TURN server " + u + " requires username and credential, or secret")
+			}
+		} else if !strings.HasPrefix(u, "stun:") && !strings.HasPrefix(u, "stuns:") {
+			return errors.New("invalid ICE server URL: " + u)
+		}
+	}
+
+	return nil
+}
+
+// Checks if any of the ICE servers has a STUN URL
+func hasSTUNServer(servers []ICEServerConfig) bool {
+	for _, server := range servers {
+		for _, u := range server.URLs {
+			if strings.HasPrefix(u, "stun:") || strings.HasPrefix(u, "stuns:") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 // This function loads WebRTC config from env variables
-func loadWebRTCConfig() webrtc.Configuration {
-	peerConnectionConfig := webrtc.Configuration{
-		ICEServers: make([]webrtc.ICEServer, 0),
+func loadWebRTCConfig() WebRTCConfig {
+	config := WebRTCConfig{
+		iceServers:         make([]ICEServerConfig, 0),
+		iceTransportPolicy: webrtc.ICETransportPolicyAll,
+		turnCredentialsTTL: 24 * time.Hour,
+	}
+
+	// List of ICE servers
+	if iceServersJSON := getSecretEnv("ICE_SERVERS"); iceServersJSON != "" {
+		err := json.Unmarshal([]byte(iceServersJSON), &config.iceServers)
+		if err != nil {
+			fmt.Println("Invalid ICE_SERVERS provided. It must be a JSON array: " + err.Error())
+			os.Exit(1)
+		}
 	}
 
 	// STUN server
 	stunServer := os.Getenv("STUN_SERVER")
 	if stunServer != "" {
-		peerConnectionConfig.ICEServers = append(peerConnectionConfig.ICEServers, webrtc.ICEServer{
+		config.iceServers = append(config.iceServers, ICEServerConfig{
 			URLs: []string{stunServer},
 		})
-	} else {
-		peerConnectionConfig.ICEServers = append(peerConnectionConfig.ICEServers, webrtc.ICEServer{
-			URLs: []string{"stun:stun.l.google.com:19302"},
-		})
 	}
 
 	// TURN server
 	turnServer := os.Getenv("TURN_SERVER")
 	if turnServer != "" {
-		peerConnectionConfig.ICEServers = append(peerConnectionConfig.ICEServers, webrtc.ICEServer{
+		config.iceServers = append(config.iceServers, ICEServerConfig{
 			URLs:       []string{turnServer},
-			Username:   os.Getenv("TURN_USERNAME"),
-			Credential: os.Getenv("TURN_PASSWORD"),
+			Username:   getSecretEnv("TURN_USERNAME"),
+			Credential: getSecretEnv("TURN_PASSWORD"),
+			Secret:     getSecretEnv("TURN_SECRET"),
+		})
+	}
+
+	for _, server := range config.iceServers {
+		if err := validateICEServerConfig(server); err != nil {
+			fmt.Println("Invalid ICE servers configuration: " + err.Error())
+			os.Exit(1)
+		}
+
+		registerSecret(server.Username)
+		registerSecret(server.Credential)
+		registerSecret(server.Secret)
+	}
+
+	// Default STUN server
+	// Also added when only TURN servers are configured, so the server reflexive candidates are gathered
+	if !hasSTUNServer(config.iceServers) && os.Getenv("ICE_DEFAULT_STUN") != "NO" {
+		config.iceServers = append(config.iceServers, ICEServerConfig{
+			URLs: []string{DEFAULT_STUN_SERVER},
 		})
 	}
 
+	// Transport policy
+	switch strings.ToLower(os.Getenv("ICE_TRANSPORT_POLICY")) {
+	case "", "all":
+		config.iceTransportPolicy = webrtc.ICETransportPolicyAll
+	case "relay":
+		config.iceTransportPolicy = webrtc.ICETransportPolicyRelay
+	default:
+		fmt.Println("Invalid ICE_TRANSPORT_POLICY provided. It can be 'all' or 'relay'.")
+		os.Exit(1)
+	}
+
+	if config.iceTransportPolicy == webrtc.ICETransportPolicyRelay && !config.hasTURN() {
+		fmt.Println("ICE_TRANSPORT_POLICY is 'relay', but there are no TURN servers configured.")
+		os.Exit(1)
+	}
+
+	if os.Getenv("TURN_CREDENTIALS_TTL") != "" {
+		ttl, err := strconv.Atoi(os.Getenv("TURN_CREDENTIALS_TTL"))
+		if err != nil || ttl <= 0 {
+			fmt.Println("Invalid TURN_CREDENTIALS_TTL provided. It must be a number of seconds.")
+			os.Exit(1)
+		}
+		config.turnCredentialsTTL = time.Duration(ttl) * time.Second
+	}
+
+	// DTLS
+	config.certificate = loadDTLSCertificate()
+	config.remoteFingerprints = loadDTLSFingerprintPins()
+
+	return config
+}
+
+// Checks if there is any TURN server configured
+func (config *WebRTCConfig) hasTURN() bool {
+	for _, server := range config.iceServers {
+		for _, u := range server.URLs {
+			if strings.HasPrefix(u, "turn:") || strings.HasPrefix(u, "turns:") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Generates time-limited TURN credentials from a shared secret (TURN REST API)
+// The username is '<expiration timestamp>:<username>' and the credential is base64(HMAC-SHA1(secret, username))
+func generateTURNCredentials(secret string, username string, ttl time.Duration) (string, string) {
+	restUsername := fmt.Sprint(time.Now().Add(ttl).Unix())
+
+	if username != "" {
+		restUsername += ":" + username
+	}
+
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(restUsername))
+
+	credential := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	registerSecret(credential)
+
+	return restUsername, credential
+}
+
+// Gets the configuration for a new peer connection
+// TURN REST credentials are generated for each call
+func (config *WebRTCConfig) PeerConnectionConfig() webrtc.Configuration {
+	peerConnectionConfig := webrtc.Configuration{
+		ICEServers:         make([]webrtc.ICEServer, 0, len(config.iceServers)),
+		ICETransportPolicy: config.iceTransportPolicy,
+	}
+
+	if config.certificate != nil {
+		peerConnectionConfig.Certificates = []webrtc.Certificate{*config.certificate}
+	}
+
+	for _, server := range config.iceServers {
+		iceServer := webrtc.ICEServer{
+			URLs:       server.URLs,
+			Username:   server.Username,
+			Credential: server.Credential,
+		}
+
+		if server.Secret != "" {
+			iceServer.Username, iceServer.Credential = generateTURNCredentials(server.Secret, server.Username, config.turnCredentialsTTL)
+		}
+
+		peerConnectionConfig.ICEServers = append(peerConnectionConfig.ICEServers, iceServer)
+	}
+
 	return peerConnectionConfig
 }
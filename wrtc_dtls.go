@@ -0,0 +1,144 @@
+// DTLS certificate and fingerprint pinning
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Expected fingerprint of the remote DTLS certificate
+type DTLSFingerprintPin struct {
+	// Hash algorithm (lowercase). Empty to match any algorithm
+	algorithm string
+
+	// Fingerprint value (uppercase hex, separated by colons)
+	value string
+}
+
+// Normalizes a fingerprint value, to compare them
+func normalizeFingerprint(value string) string {
+	return strings.ToUpper(strings.TrimSpace(value))
+}
+
+// Loads the DTLS certificate from the PEM files set in env variables
+// Returns nil if not configured, so a random certificate is generated for each connection
+func loadDTLSCertificate() *webrtc.Certificate {
+	certFile := os.Getenv("WEBRTC_DTLS_CERT_FILE")
+	keyFile := os.Getenv("WEBRTC_DTLS_KEY_FILE")
+
+	if certFile == "" && keyFile == "" {
+		return nil
+	}
+
+	keyPair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		fmt.Println("Error: Could not load the DTLS certificate (WEBRTC_DTLS_CERT_FILE, WEBRTC_DTLS_KEY_FILE): " + err.Error())
+		os.Exit(1)
+	}
+
+	switch keyPair.PrivateKey.(type) {
+	case *ecdsa.PrivateKey, *rsa.PrivateKey:
+	default:
+		fmt.Println("Error: The DTLS private key must be ECDSA or RSA.")
+		os.Exit(1)
+	}
+
+	x509Cert, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		fmt.Println("Error: Invalid DTLS certificate: " + err.Error())
+		os.Exit(1)
+	}
+
+	certificate := webrtc.CertificateFromX509(keyPair.PrivateKey, x509Cert)
+
+	fingerprints, err := certificate.GetFingerprints()
+	if err != nil {
+		fmt.Println("Error: Invalid DTLS certificate: " + err.Error())
+		os.Exit(1)
+	}
+
+	for _, fp := range fingerprints {
+		fmt.Println("DTLS certificate fingerprint: " + fp.Algorithm + " " + fp.Value + " | Expires: " + x509Cert.NotAfter.String())
+	}
+
+	return &certificate
+}
+
+// Loads the expected remote fingerprints from env variables
+// Returns an empty list if not configured, so any remote certificate is accepted
+func loadDTLSFingerprintPins() []DTLSFingerprintPin {
+	pins := make([]DTLSFingerprintPin, 0)
+
+	for _, item := range splitCommaList(os.Getenv("WEBRTC_REMOTE_FINGERPRINTS")) {
+		parts := strings.Fields(item)
+
+		switch len(parts) {
+		case 1:
+			pins = append(pins, DTLSFingerprintPin{
+				algorithm: "",
+				value:     normalizeFingerprint(parts[0]),
+			})
+		case 2:
+			pins = append(pins, DTLSFingerprintPin{
+				algorithm: strings.ToLower(parts[0]),
+				value:     normalizeFingerprint(parts[1]),
+			})
+		default:
+			fmt.Println("Invalid WEBRTC_REMOTE_FINGERPRINTS provided. Each fingerprint must be '<algorithm> <value>' or '<value>'. Example: sha-256 AB:CD:...")
+			os.Exit(1)
+		}
+	}
+
+	return pins
+}
+
+// Checks the fingerprints of a remote session description against the pinned ones
+// The DTLS handshake verifies that the remote certificate matches the fingerprints of the session description
+// Returns an error if none of the fingerprints is pinned
+func checkDTLSFingerprints(sdp string, pins []DTLSFingerprintPin) error {
+	if len(pins) == 0 {
+		return nil
+	}
+
+	found := false
+
+	for _, line := range strings.Split(sdp, "\n") {
+		line = strings.TrimSpace(line)
+
+		if !strings.HasPrefix(line, "a=fingerprint:") {
+			continue
+		}
+
+		found = true
+
+		parts := strings.Fields(strings.TrimPrefix(line, "a=fingerprint:"))
+
+		if len(parts) != 2 {
+			continue
+		}
+
+		algorithm := strings.ToLower(parts[0])
+		value := normalizeFingerprint(parts[1])
+
+		for _, pin := range pins {
+			if (pin.algorithm == "" || pin.algorithm == algorithm) && pin.value == value {
+				return nil
+			}
+		}
+	}
+
+	if !found {
+		return errors.New("the remote session description does not have a DTLS fingerprint")
+	}
+
+	return errors.New("the remote DTLS fingerprint does not match any of the expected ones")
+}
@@ -0,0 +1,204 @@
+// WebRTC networking settings
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Read buffer size for the ICE-TCP connections
+const ICE_TCP_READ_BUFFER_SIZE = 8
+
+// Splits a comma separated list, ignoring the empty elements
+func splitCommaList(list string) []string {
+	result := make([]string, 0)
+
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+
+	return result
+}
+
+// Reads a duration in milliseconds from an env variable
+// Returns the default value if not set
+func getMillisecondsEnv(name string, defaultValue time.Duration) time.Duration {
+	if os.Getenv(name) == "" {
+		return defaultValue
+	}
+
+	ms, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || ms <= 0 {
+		fmt.Println("Invalid " + name + " provided. It must be a number of milliseconds.")
+		os.Exit(1)
+	}
+
+	return time.Duration(ms) * time.Millisecond
+}
+
+// Loads the WebRTC networking settings from env variables
+// Opens the listeners for ICE-TCP and the UDP mux, if enabled
+func loadWebRTCSettingEngine() webrtc.SettingEngine {
+	settingEngine := webrtc.SettingEngine{}
+
+	// Port range
+	if os.Getenv("WEBRTC_PORT_MIN") != "" || os.Getenv("WEBRTC_PORT_MAX") != "" {
+		portMin, errMin := strconv.Atoi(os.Getenv("WEBRTC_PORT_MIN"))
+		portMax, errMax := strconv.Atoi(os.Getenv("WEBRTC_PORT_MAX"))
+
+		if errMin != nil || errMax != nil || portMin <= 0 || portMax > 65535 || portMin > portMax {
+			fmt.Println("Invalid WEBRTC_PORT_MIN and WEBRTC_PORT_MAX provided. Both must be set to valid port numbers.")
+			os.Exit(1)
+		}
+
+		err := settingEngine.SetEphemeralUDPPortRange(uint16(portMin), uint16(portMax))
+
+		if err != nil {
+			fmt.Println("Invalid WebRTC port range: " + err.Error())
+			os.Exit(1)
+		}
+	}
+
+	// NAT 1:1
+	if os.Getenv("WEBRTC_NAT_1TO1_IPS") != "" {
+		ips := splitCommaList(os.Getenv("WEBRTC_NAT_1TO1_IPS"))
+
+		for _, ip := range ips {
+			// Each element can be an IP, or a mapping 'external/internal'
+			for _, part := range strings.Split(ip, "/") {
+				if net.ParseIP(part) == nil {
+					fmt.Println("Invalid WEBRTC_NAT_1TO1_IPS provided. Invalid IP: " + ip)
+					os.Exit(1)
+				}
+			}
+		}
+
+		candidateType := webrtc.ICECandidateTypeHost
+
+		if os.Getenv("WEBRTC_NAT_1TO1_CANDIDATE_TYPE") != "" {
+			t, err := webrtc.NewICECandidateType(strings.ToLower(os.Getenv("WEBRTC_NAT_1TO1_CANDIDATE_TYPE")))
+			if err != nil || (t != webrtc.ICECandidateTypeHost && t != webrtc.ICECandidateTypeSrflx) {
+				fmt.Println("Invalid WEBRTC_NAT_1TO1_CANDIDATE_TYPE provided. It can be 'host' or 'srflx'.")
+				os.Exit(1)
+			}
+			candidateType = t
+		}
+
+		settingEngine.SetNAT1To1IPs(ips, candidateType)
+	}
+
+	// Interface filter
+	if os.Getenv("WEBRTC_INTERFACES") != "" {
+		interfaces := splitCommaList(os.Getenv("WEBRTC_INTERFACES"))
+
+		settingEngine.SetInterfaceFilter(func(name string) bool {
+			for _, i := range interfaces {
+				if i == name {
+					return true
+				}
+			}
+			return false
+		})
+	}
+
+	// IP filter
+	if os.Getenv("WEBRTC_IP_RANGES") != "" {
+		ranges := make([]*net.IPNet, 0)
+
+		for _, r := range splitCommaList(os.Getenv("WEBRTC_IP_RANGES")) {
+			_, ipNet, err := net.ParseCIDR(r)
+			if err != nil {
+				fmt.Println("Invalid WEBRTC_IP_RANGES provided. Invalid CIDR: " + r)
+				os.Exit(1)
+			}
+			ranges = append(ranges, ipNet)
+		}
+
+		settingEngine.SetIPFilter(func(ip net.IP) bool {
+			for _, r := range ranges {
+				if r.Contains(ip) {
+					return true
+				}
+			}
+			return false
+		})
+	}
+
+	// Network types
+	networkTypes := make([]webrtc.NetworkType, 0)
+
+	if os.Getenv("WEBRTC_NETWORK_TYPES") != "" {
+		for _, t := range splitCommaList(os.Getenv("WEBRTC_NETWORK_TYPES")) {
+			networkType, err := webrtc.NewNetworkType(strings.ToLower(t))
+			if err != nil {
+				fmt.Println("Invalid WEBRTC_NETWORK_TYPES provided. The network types can be: udp4, udp6, tcp4, tcp6")
+				os.Exit(1)
+			}
+			networkTypes = append(networkTypes, networkType)
+		}
+	}
+
+	// ICE-TCP
+	if os.Getenv("WEBRTC_ICE_TCP_PORT") != "" {
+		port, err := strconv.Atoi(os.Getenv("WEBRTC_ICE_TCP_PORT"))
+		if err != nil || port <= 0 || port > 65535 {
+			fmt.Println("Invalid WEBRTC_ICE_TCP_PORT provided. It must be a valid port number.")
+			os.Exit(1)
+		}
+
+		listener, err := net.ListenTCP("tcp", &net.TCPAddr{Port: port})
+		if err != nil {
+			fmt.Println("Error: Could not listen for ICE-TCP on port " + fmt.Sprint(port) + ": " + err.Error())
+			os.Exit(1)
+		}
+
+		settingEngine.SetICETCPMux(webrtc.NewICETCPMux(nil, listener, ICE_TCP_READ_BUFFER_SIZE))
+
+		if len(networkTypes) == 0 {
+			networkTypes = append(networkTypes, webrtc.NetworkTypeUDP4, webrtc.NetworkTypeUDP6, webrtc.NetworkTypeTCP4, webrtc.NetworkTypeTCP6)
+		}
+	}
+
+	if len(networkTypes) > 0 {
+		settingEngine.SetNetworkTypes(networkTypes)
+	}
+
+	// UDP mux
+	if os.Getenv("WEBRTC_UDP_MUX_PORT") != "" {
+		port, err := strconv.Atoi(os.Getenv("WEBRTC_UDP_MUX_PORT"))
+		if err != nil || port <= 0 || port > 65535 {
+			fmt.Println("Invalid WEBRTC_UDP_MUX_PORT provided. It must be a valid port number.")
+			os.Exit(1)
+		}
+
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+		if err != nil {
+			fmt.Println("Error: Could not listen for WebRTC on UDP port " + fmt.Sprint(port) + ": " + err.Error())
+			os.Exit(1)
+		}
+
+		settingEngine.SetICEUDPMux(webrtc.NewICEUDPMux(nil, conn))
+	}
+
+	// ICE timeouts
+	if os.Getenv("WEBRTC_ICE_DISCONNECTED_TIMEOUT") != "" || os.Getenv("WEBRTC_ICE_FAILED_TIMEOUT") != "" || os.Getenv("WEBRTC_ICE_KEEPALIVE_INTERVAL") != "" {
+		settingEngine.SetICETimeouts(
+			getMillisecondsEnv("WEBRTC_ICE_DISCONNECTED_TIMEOUT", 5*time.Second),
+			getMillisecondsEnv("WEBRTC_ICE_FAILED_TIMEOUT", 25*time.Second),
+			getMillisecondsEnv("WEBRTC_ICE_KEEPALIVE_INTERVAL", 2*time.Second),
+		)
+	}
+
+	return settingEngine
+}
@@ -0,0 +1,276 @@
+// WebRTC statistics
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor/pkg/stats"
+	"github.com/pion/webrtc/v3"
+)
+
+// Configuration to report the WebRTC statistics
+type WebRTCStatsConfig struct {
+	// Interval to collect and log the statistics (0 = disabled)
+	interval time.Duration
+
+	// Log the statistics as JSON, instead of text
+	json bool
+}
+
+// Loads the WebRTC statistics configuration from env variables
+func loadWebRTCStatsConfig() WebRTCStatsConfig {
+	config := WebRTCStatsConfig{
+		interval: 0,
+		json:     false,
+	}
+
+	if os.Getenv("WEBRTC_STATS_INTERVAL") != "" {
+		interval, err := strconv.Atoi(os.Getenv("WEBRTC_STATS_INTERVAL"))
+		if err != nil || interval < 0 {
+			fmt.Println("Invalid WEBRTC_STATS_INTERVAL provided. It must be a number of seconds.")
+			os.Exit(1)
+		}
+		config.interval = time.Duration(interval) * time.Second
+	}
+
+	switch strings.ToLower(os.Getenv("WEBRTC_STATS_FORMAT")) {
+	case "", "text":
+		config.json = false
+	case "json":
+		config.json = true
+	default:
+		fmt.Println("Invalid WEBRTC_STATS_FORMAT provided. It can be 'text' or 'json'.")
+		os.Exit(1)
+	}
+
+	return config
+}
+
+// Statistics of a received track
+type WebRTCTrackStats struct {
+	Kind  string `json:"kind"`
+	Codec string `json:"codec"`
+	SSRC  uint32 `json:"ssrc"`
+
+	PacketsReceived uint64  `json:"packets_received"`
+	PacketsLost     int64   `json:"packets_lost"`
+	Jitter          float64 `json:"jitter_ms"`
+	BytesReceived   uint64  `json:"bytes_received"`
+	Bitrate         float64 `json:"bitrate_kbps"`
+
+	// Feedback sent to the source
+	NACKCount uint32 `json:"nack_count"`
+	PLICount  uint32 `json:"pli_count"`
+	FIRCount  uint32 `json:"fir_count"`
+
+	LastPacket time.Time `json:"last_packet"`
+}
+
+// Statistics of the ICE candidate pair in use
+type WebRTCCandidatePairStats struct {
+	Local  string `json:"local"`
+	Remote string `json:"remote"`
+
+	RTT float64 `json:"rtt_ms"`
+
+	BytesSent     uint64 `json:"bytes_sent"`
+	BytesReceived uint64 `json:"bytes_received"`
+}
+
+// Statistics of the WebRTC session
+type WebRTCStats struct {
+	ConnectionState string `json:"connection_state"`
+
+	Tracks []WebRTCTrackStats `json:"tracks"`
+
+	// nil if no candidate pair was selected yet
+	CandidatePair *WebRTCCandidatePairStats `json:"candidate_pair"`
+
+	// Timestamp of the collection
+	Updated time.Time `json:"updated"`
+}
+
+// Gets a summary of the statistics, for the logs
+func (s WebRTCStats) String() string {
+	lines := make([]string, 0, len(s.Tracks)+1)
+
+	for _, t := range s.Tracks {
+		lines = append(lines, "["+strings.ToUpper(t.Kind)+"] "+codecNameFromMimeType(t.Codec)+
+			" bitrate="+fmt.Sprintf("%.1f", t.Bitrate)+"kbps"+
+			" packets="+fmt.Sprint(t.PacketsReceived)+
+			" lost="+fmt.Sprint(t.PacketsLost)+
+			" jitter="+fmt.Sprintf("%.1f", t.Jitter)+"ms"+
+			" nack="+fmt.Sprint(t.NACKCount)+
+			" pli="+fmt.Sprint(t.PLICount)+
+			" fir="+fmt.Sprint(t.FIRCount))
+	}
+
+	if s.CandidatePair != nil {
+		lines = append(lines, "[ICE] "+s.CandidatePair.Local+" <-> "+s.CandidatePair.Remote+
+			" rtt="+fmt.Sprintf("%.1f", s.CandidatePair.RTT)+"ms")
+	} else {
+		lines = append(lines, "[ICE] state="+s.ConnectionState+" | No candidate pair selected")
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+var (
+	webrtc_stats_lock = &sync.Mutex{}
+	webrtc_stats      *WebRTCStats
+)
+
+// Gets the last statistics collected from the WebRTC session
+func getWebRTCStats() (WebRTCStats, bool) {
+	webrtc_stats_lock.Lock()
+	defer webrtc_stats_lock.Unlock()
+
+	if webrtc_stats == nil {
+		return WebRTCStats{}, false
+	}
+
+	return *webrtc_stats, true
+}
+
+// Describes an ICE candidate, for the statistics
+func describeICECandidate(c webrtc.ICECandidateStats) string {
+	return c.CandidateType.String() + " " + c.Protocol + " " + c.IP + ":" + fmt.Sprint(c.Port)
+}
+
+// Gets the time of the last packet received for a track
+// Returns the zero time if no packets were received
+func getTrackLastPacket(getter stats.Getter, track *webrtc.TrackRemote) time.Time {
+	if getter == nil || track == nil {
+		return time.Time{}
+	}
+
+	s := getter.Get(uint32(track.SSRC()))
+
+	if s == nil {
+		return time.Time{}
+	}
+
+	return s.InboundRTPStreamStats.LastPacketReceivedTimestamp
+}
+
+// Collects the statistics of a WebRTC session
+// getter - Stats of the RTP streams, from the stats interceptor
+// tracks - Current tracks of the session
+// previous - Previous statistics, to compute the bitrates
+func collectWebRTCStats(pc *webrtc.PeerConnection, getter stats.Getter, tracks []*webrtc.TrackRemote, previous *WebRTCStats) WebRTCStats {
+	now := time.Now()
+
+	result := WebRTCStats{
+		ConnectionState: pc.ConnectionState().String(),
+		Tracks:          make([]WebRTCTrackStats, 0, len(tracks)),
+		CandidatePair:   nil,
+		Updated:         now,
+	}
+
+	for _, track := range tracks {
+		trackStats := WebRTCTrackStats{
+			Kind:  track.Kind().String(),
+			Codec: track.Codec().MimeType,
+			SSRC:  uint32(track.SSRC()),
+		}
+
+		if s := getter.Get(trackStats.SSRC); s != nil {
+			trackStats.PacketsReceived = s.InboundRTPStreamStats.PacketsReceived
+			trackStats.PacketsLost = s.InboundRTPStreamStats.PacketsLost
+			trackStats.Jitter = s.InboundRTPStreamStats.Jitter * 1000
+			trackStats.BytesReceived = s.InboundRTPStreamStats.BytesReceived
+			trackStats.NACKCount = s.InboundRTPStreamStats.NACKCount
+			trackStats.PLICount = s.InboundRTPStreamStats.PLICount
+			trackStats.FIRCount = s.InboundRTPStreamStats.FIRCount
+			trackStats.LastPacket = s.InboundRTPStreamStats.LastPacketReceivedTimestamp
+		}
+
+		if previous != nil {
+			for _, p := range previous.Tracks {
+				elapsed := now.Sub(previous.Updated).Seconds()
+
+				if p.SSRC == trackStats.SSRC && elapsed > 0 && trackStats.BytesReceived >= p.BytesReceived {
+					trackStats.Bitrate = float64(trackStats.BytesReceived-p.BytesReceived) * 8 / 1000 / elapsed
+				}
+			}
+		}
+
+		result.Tracks = append(result.Tracks, trackStats)
+	}
+
+	// Find the selected candidate pair
+	report := pc.GetStats()
+
+	for _, s := range report {
+		pair, ok := s.(webrtc.ICECandidatePairStats)
+
+		if !ok || !pair.Nominated || pair.State != webrtc.StatsICECandidatePairStateSucceeded {
+			continue
+		}
+
+		local, okLocal := report[pair.LocalCandidateID].(webrtc.ICECandidateStats)
+		remote, okRemote := report[pair.RemoteCandidateID].(webrtc.ICECandidateStats)
+
+		if !okLocal || !okRemote {
+			continue
+		}
+
+		result.CandidatePair = &WebRTCCandidatePairStats{
+			Local:         describeICECandidate(local),
+			Remote:        describeICECandidate(remote),
+			RTT:           pair.CurrentRoundTripTime * 1000,
+			BytesSent:     pair.BytesSent,
+			BytesReceived: pair.BytesReceived,
+		}
+
+		break
+	}
+
+	return result
+}
+
+// Collects and logs the statistics of a WebRTC session periodically
+// getTracks - Gets the current tracks of the session
+// Runs until the peer connection is closed
+func monitorWebRTCStats(pc *webrtc.PeerConnection, getter stats.Getter, getTracks func() []*webrtc.TrackRemote, config WebRTCStatsConfig) {
+	if config.interval <= 0 || getter == nil {
+		return
+	}
+
+	ticker := time.NewTicker(config.interval)
+	defer ticker.Stop()
+
+	var previous *WebRTCStats = nil
+
+	for range ticker.C {
+		if pc.ConnectionState() == webrtc.PeerConnectionStateClosed {
+			return
+		}
+
+		current := collectWebRTCStats(pc, getter, getTracks(), previous)
+		previous = &current
+
+		webrtc_stats_lock.Lock()
+		webrtc_stats = &current
+		webrtc_stats_lock.Unlock()
+
+		if config.json {
+			j, err := json.Marshal(current)
+
+			if err == nil {
+				fmt.Println(string(j))
+			}
+		} else {
+			for _, line := range strings.Split(current.String(), "\n") {
+				fmt.Println("[STATS] " + line)
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Websocket connection options
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Options to connect to the signaling server
+type SignalingConnectionConfig struct {
+	// Dialer to connect to the websocket
+	dialer *websocket.Dialer
+
+	// Extra HTTP headers for the websocket handshake
+	headers http.Header
+}
+
+// Loads the signaling connection configuration from env variables
+func loadSignalingConnectionConfig() SignalingConnectionConfig {
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 45 * time.Second,
+	}
+
+	// TLS
+	tlsConfig := &tls.Config{}
+
+	if os.Getenv("SIGNALING_CA_FILE") != "" {
+		caPEM, err := os.ReadFile(os.Getenv("SIGNALING_CA_FILE"))
+		if err != nil {
+			fmt.Println("Error: Could not read SIGNALING_CA_FILE: " + err.Error())
+			os.Exit(1)
+		}
+
+		certPool := x509.NewCertPool()
+
+		if !certPool.AppendCertsFromPEM(caPEM) {
+			fmt.Println("Error: SIGNALING_CA_FILE does not contain any valid PEM certificate")
+			os.Exit(1)
+		}
+
+		tlsConfig.RootCAs = certPool
+	}
+
+	if os.Getenv("SIGNALING_CLIENT_CERT_FILE") != "" || os.Getenv("SIGNALING_CLIENT_KEY_FILE") != "" {
+		cert, err := tls.LoadX509KeyPair(os.Getenv("SIGNALING_CLIENT_CERT_FILE"), os.Getenv("SIGNALING_CLIENT_KEY_FILE"))
+		if err != nil {
+			fmt.Println("Error: Could not load the client certificate (SIGNALING_CLIENT_CERT_FILE, SIGNALING_CLIENT_KEY_FILE): " + err.Error())
+			os.Exit(1)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if os.Getenv("SIGNALING_TLS_INSECURE") == "YES" {
+		fmt.Println("Warning: SIGNALING_TLS_INSECURE is enabled. The certificate of the signaling server will not be verified.")
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	dialer.TLSClientConfig = tlsConfig
+
+	// Proxy
+	if os.Getenv("SIGNALING_PROXY") != "" {
+		proxyURL, err := url.Parse(os.Getenv("SIGNALING_PROXY"))
+		if err != nil || (proxyURL.Scheme != "http" && proxyURL.Scheme != "socks5") {
+			// The websocket dialer does not support TLS connections to the proxy
+			fmt.Println("Invalid SIGNALING_PROXY provided. It must be a valid http or socks5 URL.")
+			os.Exit(1)
+		}
+
+		registerURLSecrets(os.Getenv("SIGNALING_PROXY"))
+
+		dialer.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	// Handshake timeout
+	if os.Getenv("SIGNALING_HANDSHAKE_TIMEOUT") != "" {
+		handshakeTimeout, err := strconv.Atoi(os.Getenv("SIGNALING_HANDSHAKE_TIMEOUT"))
+		if err != nil || handshakeTimeout <= 0 {
+			fmt.Println("Invalid SIGNALING_HANDSHAKE_TIMEOUT provided. It must be a number of seconds.")
+			os.Exit(1)
+		}
+		dialer.HandshakeTimeout = time.Duration(handshakeTimeout) * time.Second
+	}
+
+	// Headers
+	headers := http.Header{}
+
+	if headersJSON := getSecretEnv("SIGNALING_HEADERS"); headersJSON != "" {
+		headersMap := make(map[string]string)
+
+		err := json.Unmarshal([]byte(headersJSON), &headersMap)
+		if err != nil {
+			fmt.Println("Invalid SIGNALING_HEADERS provided. It must be a JSON object. Example: {\"Authorization\": \"Bearer token\"}")
+			os.Exit(1)
+		}
+
+		for key, val := range headersMap {
+			registerSecret(val)
+			headers.Set(key, val)
+		}
+	}
+
+	return SignalingConnectionConfig{
+		dialer:  dialer,
+		headers: headers,
+	}
+}